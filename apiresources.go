@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// List the preferred version of every resource served by the cluster, with
+// Group and Version filled in from the API group it belongs to
+func listAPIResources(config *rest.Config) ([]metav1.APIResource, error) {
+	discoveryClient, err := newDiscoveryClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Groups that fail discovery are skipped, the others are still listed
+	lists, err := discoveryClient.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("discovering API resources: %w", err)
+	} else if err != nil {
+		slog.Warn("Some API groups could not be discovered", "err", err)
+	}
+
+	var resources []metav1.APIResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("parsing group version %q: %w", list.GroupVersion, err)
+		}
+		for _, resource := range list.APIResources {
+			// Subresources such as pods/log can't be listed on their own
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			resource.Group = gv.Group
+			resource.Version = gv.Version
+			resources = append(resources, resource)
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	return resources, nil
+}
+
+// Print the API resources as an aligned table
+func printAPIResources(resources []metav1.APIResource) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tAPIVERSION\tNAMESPACED\tKIND\tVERBS")
+	for _, resource := range resources {
+		apiVersion := schema.GroupVersion{Group: resource.Group, Version: resource.Version}.String()
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", resource.Name, apiVersion, resource.Namespaced, resource.Kind, strings.Join(resource.Verbs, ","))
+	}
+	return w.Flush()
+}
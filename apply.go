@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+
+	"gitops/pkg/kube"
+)
+
+// How often an update that conflicts with a concurrent change is retried
+// against the latest version of the object
+var conflictRetries = 5
+
+// Backoff between applies of a manifest whose update conflicted, with
+// --conflict-retries retries after the first attempt
+func conflictBackoff() wait.Backoff {
+	backoff := retryBackoff()
+	backoff.Steps = max(conflictRetries, 0) + 1
+	return backoff
+}
+
+// Create the object, or update it in place when it already exists.
+// The returned bool reports whether the object was newly created. Updates
+// that conflict with a concurrent change re-read the live object and apply
+// the manifest onto its fresh resourceVersion, backing off between attempts
+// up to conflictRetries times. Transient errors are retried per call.
+func applyResource(ctx context.Context, resource dynamic.ResourceInterface, obj *unstructured.Unstructured) (*unstructured.Unstructured, bool, error) {
+	var result *unstructured.Unstructured
+	var created bool
+	attempt := 0
+	err := retry.RetryOnConflict(conflictBackoff(), func() error {
+		attempt++
+		// Every attempt starts from the desired manifest, not the last try
+		var err error
+		result, created, err = createOrUpdate(ctx, resource, obj.DeepCopy())
+		if errors.IsConflict(err) {
+			slog.Debug("Update conflicted, retrying on the latest version", "kind", obj.GetKind(), "name", obj.GetName(), "attempt", attempt)
+		}
+		return err
+	})
+	if errors.IsConflict(err) {
+		return nil, false, fmt.Errorf("%q still conflicts after %d retries: %w", obj.GetName(), conflictRetries, err)
+	}
+	return result, created, err
+}
+
+// A single create-or-update attempt for applyResource
+func createOrUpdate(ctx context.Context, resource dynamic.ResourceInterface, obj *unstructured.Unstructured) (*unstructured.Unstructured, bool, error) {
+	// Creates must not carry a resourceVersion left over from an earlier attempt
+	obj.SetResourceVersion("")
+	var created *unstructured.Unstructured
+	err := retryTransient(func() (err error) {
+		created, err = resource.Create(ctx, obj, metav1.CreateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+	if err == nil {
+		return created, true, nil
+	}
+	if !errors.IsAlreadyExists(err) {
+		return nil, false, err
+	}
+
+	// Updates must carry the live resourceVersion
+	var current *unstructured.Unstructured
+	err = retryTransient(func() (err error) {
+		current, err = resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	if mergeData {
+		if err := mergeConfigData(obj, current); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Conflicts are left to applyResource, which re-reads the object
+	var updated *unstructured.Unstructured
+	err = retryTransient(func() (err error) {
+		updated, err = resource.Update(ctx, obj, metav1.UpdateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return updated, false, nil
+}
+
+// Keep the data keys of a live ConfigMap or Secret that the manifest
+// doesn't set, instead of replacing the whole data map on update
+func mergeConfigData(obj, current *unstructured.Unstructured) error {
+	if kind := obj.GetKind(); kind != "ConfigMap" && kind != "Secret" {
+		return nil
+	}
+
+	existing, _, err := unstructured.NestedStringMap(current.Object, "data")
+	if err != nil {
+		return fmt.Errorf("reading data of %q: %w", current.GetName(), err)
+	}
+	desired, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return fmt.Errorf("reading data of %q: %w", obj.GetName(), err)
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	merged := existing
+	for key, value := range desired {
+		merged[key] = value
+	}
+	return unstructured.SetNestedStringMap(obj.Object, merged, "data")
+}
+
+// Dry-run modes accepted by --dry-run
+const (
+	dryRunNone   = "none"
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+// The DryRun value for mutating requests, so that --dry-run=server has the
+// API server validate the request without persisting it
+func dryRunOptions() []string {
+	if dryRun == dryRunServer {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// Map a --cascade value to the deletion propagation policy the API expects
+func propagationPolicy(cascade string) (*metav1.DeletionPropagation, error) {
+	var policy metav1.DeletionPropagation
+	switch cascade {
+	case "", "background":
+		policy = metav1.DeletePropagationBackground
+	case "foreground":
+		policy = metav1.DeletePropagationForeground
+	case "orphan":
+		policy = metav1.DeletePropagationOrphan
+	default:
+		return nil, fmt.Errorf("unknown cascade mode %q, expected background, foreground or orphan", cascade)
+	}
+	return &policy, nil
+}
+
+// Build the options for deleting manifests from the --cascade and --grace-period flags
+func deleteOptions() (metav1.DeleteOptions, error) {
+	policy, err := propagationPolicy(cascade)
+	if err != nil {
+		return metav1.DeleteOptions{}, err
+	}
+
+	options := metav1.DeleteOptions{
+		PropagationPolicy: policy,
+		DryRun:            dryRunOptions(),
+	}
+	// A negative grace period keeps the default of the object
+	if gracePeriod >= 0 {
+		options.GracePeriodSeconds = &gracePeriod
+	}
+	return options, nil
+}
+
+// Name of the tool, sent in the user agent and the managed-by label
+const appName = "client-go-learning"
+
+// Field manager recorded in managedFields for creates, updates and patches,
+// set with --field-manager
+var fieldManager = appName
+
+// Apply the object with server-side apply. With force set, conflicting
+// fields owned by other managers are taken over instead of rejected.
+func serverSideApply(ctx context.Context, resource dynamic.ResourceInterface, obj *unstructured.Unstructured, force bool) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// Field manager conflicts need user action, so only transient errors are retried
+	var applied *unstructured.Unstructured
+	err = retryTransient(func() error {
+		applied, err = resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: fieldManager,
+			DryRun:       dryRunOptions(),
+			Force:        &force,
+		})
+		return err
+	})
+	if errors.IsConflict(err) {
+		return nil, fmt.Errorf("field manager conflict applying %q, rerun with --force-conflicts to take ownership: %w", obj.GetName(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Set spec.replicas of a workload and update it. Kinds without a replicas
+// field, such as ConfigMaps or bare Pods, are rejected.
+func scaleResource(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace string, name string, replicas int64) error {
+	resource := kube.NamespacedResource(dynamicClient, gvr, namespace)
+	return retryOnConflict(func() error {
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, found, err := unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas"); err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("%s %q has no spec.replicas field and cannot be scaled", obj.GetKind(), name)
+		}
+		if err := unstructured.SetNestedField(obj.Object, replicas, "spec", "replicas"); err != nil {
+			return err
+		}
+
+		_, err = resource.Update(ctx, obj, metav1.UpdateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+}
+
+// Map a --patch-type value to the patch type of the request
+func parsePatchType(patchType string) (types.PatchType, error) {
+	switch patchType {
+	case "merge":
+		return types.MergePatchType, nil
+	case "json":
+		return types.JSONPatchType, nil
+	case "strategic":
+		return types.StrategicMergePatchType, nil
+	default:
+		return "", fmt.Errorf("unknown patch type %q, expected merge, json or strategic", patchType)
+	}
+}
+
+// Patch an existing resource. Strategic merge patches only work for built-in
+// kinds, custom resources need a merge or JSON patch.
+func patchResource(ctx context.Context, resource dynamic.ResourceInterface, name string, patch []byte, pt types.PatchType) (*unstructured.Unstructured, error) {
+	var patched *unstructured.Unstructured
+	err := retryTransient(func() error {
+		var err error
+		patched, err = resource.Patch(ctx, name, pt, patch, metav1.PatchOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// Create the namespace unless it already exists. The returned bool reports
+// whether the namespace was newly created.
+func ensureNamespace(ctx context.Context, dynamicClient dynamic.Interface, name string) (bool, error) {
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(name)
+
+	namespaces := dynamicClient.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"})
+	err := retryTransient(func() error {
+		_, err := namespaces.Create(ctx, ns, metav1.CreateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+	if errors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("creating namespace %q: %w", name, err)
+	}
+	return true, nil
+}
+
+// Read an applied object back and check that it materialized, to catch
+// admission webhooks that silently dropped or rejected it. Controllers that
+// report status.observedGeneration must have seen the latest generation.
+func verifyApplied(ctx context.Context, resource dynamic.ResourceInterface, name string) error {
+	obj, err := kube.GetResource(ctx, resource, name)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("%q does not exist after it was applied", name)
+	}
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() == "" {
+		return fmt.Errorf("%q has no metadata.uid", name)
+	}
+
+	observed, found, err := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if err != nil {
+		return err
+	}
+	if found && observed != obj.GetGeneration() {
+		return fmt.Errorf("%q status.observedGeneration %d doesn't match generation %d", name, observed, obj.GetGeneration())
+	}
+	return nil
+}
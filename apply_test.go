@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func newConfigMap(name string, data map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"data":       data,
+	}}
+}
+
+// Fail the first conflicts updates of configmaps with a 409, counting every update
+func conflictReactor(conflicts int, updates *int) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*updates++
+		if *updates <= conflicts {
+			name := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured).GetName()
+			return true, nil, apierrors.NewConflict(configMapGVR.GroupResource(), name, errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	}
+}
+
+func TestApplyResourceRetriesConflicts(t *testing.T) {
+	retryDelay = time.Millisecond
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newConfigMap("app", map[string]interface{}{"key": "old"}))
+	updates := 0
+	client.PrependReactor("update", "configmaps", conflictReactor(2, &updates))
+
+	resource := client.Resource(configMapGVR).Namespace("default")
+	result, created, err := applyResource(context.Background(), resource, newConfigMap("app", map[string]interface{}{"key": "new"}))
+	if err != nil {
+		t.Fatalf("applyResource: %v", err)
+	}
+	if created {
+		t.Error("created = true, want an update of the existing object")
+	}
+	if updates != 3 {
+		t.Errorf("got %d updates, want 2 conflicts and 1 success", updates)
+	}
+	if value, _, _ := unstructured.NestedString(result.Object, "data", "key"); value != "new" {
+		t.Errorf("data.key = %q, want new", value)
+	}
+}
+
+func TestApplyResourceGivesUpAfterConflictRetries(t *testing.T) {
+	retryDelay = time.Millisecond
+	conflictRetries = 2
+	defer func() { conflictRetries = 5 }()
+
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newConfigMap("app", nil))
+	updates := 0
+	client.PrependReactor("update", "configmaps", conflictReactor(100, &updates))
+
+	resource := client.Resource(configMapGVR).Namespace("default")
+	_, _, err := applyResource(context.Background(), resource, newConfigMap("app", map[string]interface{}{"key": "new"}))
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("err = %v, want a conflict", err)
+	}
+	if updates != 3 {
+		t.Errorf("got %d updates, want 1 attempt and 2 retries", updates)
+	}
+}
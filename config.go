@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Config loading modes accepted by --config-mode
+const (
+	configModeAuto       = "auto"
+	configModeInCluster  = "in-cluster"
+	configModeKubeconfig = "kubeconfig"
+)
+
+// Version reported in the User-Agent, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// Build the cluster config and act as the --as user when one is given
+func buildConfig() (*rest.Config, error) {
+	if caFile != "" && insecureTLS {
+		return nil, errors.New("--certificate-authority and --insecure-skip-tls-verify can't be combined")
+	}
+	if err := validateAuthFlags(); err != nil {
+		return nil, err
+	}
+	if err := validateServer(server); err != nil {
+		return nil, err
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Talk to the --server endpoint, such as a tunnel, instead of the kubeconfig's
+	if server != "" {
+		config.Host = server
+	}
+
+	// Trust a CA bundle the kubeconfig doesn't carry, or none at all
+	if caFile != "" {
+		config.TLSClientConfig.CAFile = caFile
+		config.TLSClientConfig.CAData = nil
+	}
+	// Authenticate with the token alone, dropping the kubeconfig's credentials
+	if token != "" {
+		config.BearerToken = token
+		config.BearerTokenFile = ""
+		config.Username = ""
+		config.Password = ""
+		config.AuthProvider = nil
+		config.ExecProvider = nil
+		config.TLSClientConfig.CertFile = ""
+		config.TLSClientConfig.KeyFile = ""
+		config.TLSClientConfig.CertData = nil
+		config.TLSClientConfig.KeyData = nil
+	}
+	if insecureTLS {
+		slog.Warn("TLS verification is disabled with --insecure-skip-tls-verify, the API server's identity is not checked and traffic can be intercepted")
+		config.TLSClientConfig.Insecure = true
+		config.TLSClientConfig.CAFile = ""
+		config.TLSClientConfig.CAData = nil
+	}
+
+	// Identify the tool in audit logs and raise client-side rate limits
+	config.UserAgent = fmt.Sprintf("%s/%s", appName, version)
+	config.QPS = qps
+	config.Burst = burst
+
+	if asUser != "" || len(asGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{UserName: asUser, Groups: asGroups}
+		slog.Debug("Impersonating", "user", asUser, "groups", asGroups)
+	}
+	return config, nil
+}
+
+// Reject credential flags that contradict each other. In-cluster config
+// always authenticates as the pod's service account, so it can't be
+// combined with --token.
+func validateAuthFlags() error {
+	if token != "" && configMode == configModeInCluster {
+		return fmt.Errorf("--token can't be combined with --config-mode %s, which uses the pod's service account token", configModeInCluster)
+	}
+	if strings.TrimSpace(token) != token {
+		return errors.New("--token must not start or end with whitespace")
+	}
+	return nil
+}
+
+// Check that --server is an http(s) URL with a host, such as
+// https://1.2.3.4:6443
+func validateServer(server string) error {
+	if server == "" {
+		return nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid --server %q: %w", server, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid --server %q, expected a URL such as https://1.2.3.4:6443", server)
+	}
+	return nil
+}
+
+// Load the cluster config, preferring the in-cluster service account and
+// falling back to the kubeconfig file unless a mode is forced
+func loadConfig() (*rest.Config, error) {
+	switch configMode {
+	case "", configModeAuto:
+		// A named context only exists in the kubeconfig
+		if kubeContext == "" {
+			if config, err := rest.InClusterConfig(); err == nil {
+				return config, nil
+			}
+		}
+		return loadKubeconfig(kubeconfig, kubeContext)
+	case configModeInCluster:
+		return rest.InClusterConfig()
+	case configModeKubeconfig:
+		return loadKubeconfig(kubeconfig, kubeContext)
+	default:
+		return nil, fmt.Errorf("unknown config mode %q, expected %s, %s or %s", configMode, configModeAuto, configModeInCluster, configModeKubeconfig)
+	}
+}
+
+// Namespace of namespaced objects that don't set one: the namespace of the
+// kubeconfig context, the pod's namespace when running in a cluster, or
+// "default"
+func contextNamespace() string {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).Namespace()
+	if err != nil || namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
+
+// Resolve the namespace of a namespaced object. An explicit -n wins over the
+// manifest's metadata.namespace, which wins over the context default.
+func resolveNamespace(manifestNamespace, defaultNamespace string) string {
+	switch {
+	case namespaceArg != "":
+		return namespaceArg
+	case manifestNamespace != "":
+		return manifestNamespace
+	default:
+		return defaultNamespace
+	}
+}
+
+// Load the kubeconfig, honoring a named context when one is given. Without
+// an explicit path the files listed in KUBECONFIG are merged, falling back
+// to ~/.kube/config. With --server set no kubeconfig is needed at all.
+func loadKubeconfig(path string, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context, ClusterInfo: clientcmdapi.Cluster{Server: server}},
+	).ClientConfig()
+}
+
+// Flags that fall back to a CGL_ environment variable, such as CGL_NAMESPACE
+// for --namespace, when they are not given on the command line. A flag wins
+// over its variable, which wins over the built-in default.
+var envFlags = []string{
+	"kubeconfig",
+	"context",
+	"config-mode",
+	"namespace",
+	"output",
+	"selector",
+	"field-manager",
+	"log-level",
+	"cache-dir",
+}
+
+// Environment variable of a flag, such as CGL_FIELD_MANAGER for --field-manager
+func envName(flagName string) string {
+	return "CGL_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Pick the value of a flag given on the command line, even an empty one so
+// that --cache-dir "" still disables the disk cache, else the environment
+// variable when set, else the flag's default
+func flagOrEnv(flag *pflag.Flag, envName string) string {
+	if flag.Changed {
+		return flag.Value.String()
+	}
+	if value := os.Getenv(envName); value != "" {
+		return value
+	}
+	return flag.DefValue
+}
+
+// Set every envFlags flag to its flagOrEnv value
+func applyEnvFlags() error {
+	for _, name := range envFlags {
+		flag := pflag.Lookup(name)
+		if err := flag.Value.Set(flagOrEnv(flag, envName(name))); err != nil {
+			return fmt.Errorf("%s: %w", envName(name), err)
+		}
+	}
+	return nil
+}
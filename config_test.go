@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Kubeconfig with a current dev context and a second staging context
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+    namespace: apps
+- name: staging
+  context:
+    cluster: staging
+    user: dev
+users:
+- name: dev
+  user:
+    token: dev-token
+`
+
+// Point KUBECONFIG at a temporary copy of testKubeconfig
+func setTestKubeconfig(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+}
+
+func TestLoadKubeconfigFromEnv(t *testing.T) {
+	setTestKubeconfig(t)
+
+	tests := []struct {
+		context string
+		want    string
+	}{
+		{"", "https://dev.example.com:6443"},
+		{"staging", "https://staging.example.com:6443"},
+	}
+	for _, tt := range tests {
+		config, err := loadKubeconfig("", tt.context)
+		if err != nil {
+			t.Fatalf("loadKubeconfig(%q): %v", tt.context, err)
+		}
+		if config.Host != tt.want {
+			t.Errorf("loadKubeconfig(%q).Host = %q, want %q", tt.context, config.Host, tt.want)
+		}
+		if config.BearerToken != "dev-token" {
+			t.Errorf("loadKubeconfig(%q).BearerToken = %q, want dev-token", tt.context, config.BearerToken)
+		}
+	}
+}
+
+func TestLoadKubeconfigUnknownContext(t *testing.T) {
+	setTestKubeconfig(t)
+
+	if _, err := loadKubeconfig("", "missing"); err == nil {
+		t.Error("got no error for a context that isn't in the kubeconfig")
+	}
+}
+
+func TestResolveNamespace(t *testing.T) {
+	tests := []struct {
+		name              string
+		flag              string
+		manifestNamespace string
+		want              string
+	}{
+		{"flag wins over the manifest", "override", "manifest", "override"},
+		{"manifest wins over the context", "", "manifest", "manifest"},
+		{"context default", "", "", "context"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespaceArg = tt.flag
+			defer func() { namespaceArg = "" }()
+
+			if got := resolveNamespace(tt.manifestNamespace, "context"); got != tt.want {
+				t.Errorf("resolveNamespace(%q, context) with -n %q = %q, want %q", tt.manifestNamespace, tt.flag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextNamespace(t *testing.T) {
+	setTestKubeconfig(t)
+	defer func() { kubeContext = "" }()
+
+	// The dev context sets a namespace, the staging context doesn't
+	for context, want := range map[string]string{"": "apps", "staging": "default"} {
+		kubeContext = context
+		if got := contextNamespace(); got != want {
+			t.Errorf("contextNamespace() with --context %q = %q, want %q", context, got, want)
+		}
+	}
+}
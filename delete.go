@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+
+	"gitops/pkg/kube"
+)
+
+// Delete every object of a resource type matching the label selector, in
+// one namespace or across all of them when namespace is empty. Returns how
+// many objects were deleted.
+func deleteBySelector(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace, labelSelector string) (int, error) {
+	options, err := deleteOptions()
+	if err != nil {
+		return 0, err
+	}
+	listCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	items, err := kube.GetResourcesDynamically(dynamicClient, listCtx, gvr, namespace, listParams(labelSelector, ""))
+	if err != nil {
+		return 0, fmt.Errorf("listing %s: %w", gvr.Resource, err)
+	}
+
+	deleted := 0
+	for _, item := range items {
+		if dryRun == dryRunClient {
+			slog.Info("Resource would be deleted (dry run)", "resource", gvr.Resource, "namespace", item.GetNamespace(), "name", item.GetName())
+			deleted++
+			continue
+		}
+		resource := kube.NamespacedResource(dynamicClient, gvr, item.GetNamespace())
+		if err := deleteObject(ctx, resource, item.GetName(), options); err != nil {
+			return deleted, fmt.Errorf("deleting %s %q: %w", gvr.Resource, item.GetName(), err)
+		}
+		slog.Info("Resource deleted", "resource", gvr.Resource, "namespace", item.GetNamespace(), "name", item.GetName())
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Delete the --resource objects matched by -l. An empty selector would
+// match everything, so it needs --all.
+func runDeleteBySelector(ctx context.Context) error {
+	if resourceArg == "" {
+		return errors.New("--delete needs --resource, such as --resource deployments")
+	}
+	if selector == "" && !deleteAll {
+		return errors.New("refusing to delete without a label selector, pass -l or --all")
+	}
+
+	config, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("loading cluster config: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := newDiscoveryClient(config)
+	if err != nil {
+		return err
+	}
+	mapping, err := kube.MappingForResource(restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient), resourceArg)
+	if err != nil {
+		return err
+	}
+
+	namespace := resolveNamespace("", contextNamespace())
+	if allNS || mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = metav1.NamespaceAll
+	}
+	deleted, err := deleteBySelector(ctx, dynamicClient, mapping.Resource, namespace, selector)
+	slog.Info("Finished", "deleted", deleted)
+	return err
+}
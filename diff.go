@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Unified diff between the live object and the manifest, both stripped of
+// server-managed fields. A nil live object shows the whole manifest as added.
+func diffManifest(live *unstructured.Unstructured, manifest *unstructured.Unstructured) (string, error) {
+	var liveYAML []byte
+	if live != nil {
+		var err error
+		liveYAML, err = yaml.Marshal(exportResource(live).Object)
+		if err != nil {
+			return "", err
+		}
+	}
+	manifestYAML, err := yaml.Marshal(exportResource(manifest).Object)
+	if err != nil {
+		return "", err
+	}
+
+	name := manifest.GetKind() + "/" + manifest.GetName()
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveYAML)),
+		B:        difflib.SplitLines(string(manifestYAML)),
+		FromFile: "live/" + name,
+		ToFile:   "manifest/" + name,
+		Context:  3,
+	})
+}
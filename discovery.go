@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/disk"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+)
+
+// How long cached discovery results are trusted, the same as kubectl
+const discoveryCacheTTL = 6 * time.Hour
+
+// Characters not allowed in the per-cluster cache directory name
+var unsafeCacheChars = regexp.MustCompile(`[^(\w/.)]`)
+
+// Cache directories already cleared with --invalidate-cache, so each
+// cluster of --contexts is invalidated once
+var (
+	invalidated   = make(map[string]bool)
+	invalidatedMu sync.Mutex
+)
+
+// Create a discovery client backed by the --cache-dir disk cache shared with
+// kubectl, or by an in-memory cache when --cache-dir is empty. Each run
+// creates one and shares it, so lookups reuse what it already fetched.
+func newDiscoveryClient(config *rest.Config) (discovery.CachedDiscoveryInterface, error) {
+	if cacheDir == "" {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return memory.NewMemCacheClient(discoveryClient), nil
+	}
+
+	discoveryDir := filepath.Join(cacheDir, "discovery", cacheDirForHost(config.Host))
+	httpDir := filepath.Join(cacheDir, "http")
+	discoveryClient, err := disk.NewCachedDiscoveryClientForConfig(config, discoveryDir, httpDir, discoveryCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	if resetCache {
+		invalidatedMu.Lock()
+		if !invalidated[discoveryDir] {
+			discoveryClient.Invalidate()
+			invalidated[discoveryDir] = true
+		}
+		invalidatedMu.Unlock()
+	}
+	return discoveryClient, nil
+}
+
+// Name the cache directory of a cluster after its host, such as
+// "https://10.0.0.1:6443" becoming "10.0.0.1_6443", like kubectl does
+func cacheDirForHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	return unsafeCacheChars.ReplaceAllString(host, "_")
+}
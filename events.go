@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// List the events about the named object, oldest first. With --since only
+// events last seen within that duration are kept.
+func getEventsFor(ctx context.Context, dynamicClient dynamic.Interface, namespace, involvedName string) ([]unstructured.Unstructured, error) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	events, err := kube.GetResourcesDynamically(dynamicClient, ctx, gvr, namespace, listParams("", "involvedObject.name="+involvedName))
+	if err != nil {
+		return nil, fmt.Errorf("listing events for %q: %w", involvedName, err)
+	}
+	if since > 0 {
+		events = eventsSince(events, time.Now().Add(-since))
+	}
+
+	// RFC 3339 timestamps sort in time order as strings
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTimestamp(events[i]) < eventTimestamp(events[j])
+	})
+	return events, nil
+}
+
+// Keep the events last seen at or after cutoff. Events without a parsable
+// timestamp are kept.
+func eventsSince(events []unstructured.Unstructured, cutoff time.Time) []unstructured.Unstructured {
+	recent := make([]unstructured.Unstructured, 0, len(events))
+	for _, event := range events {
+		// RFC3339 parsing also accepts the fractional seconds of eventTime
+		seen, err := time.Parse(time.RFC3339, eventTimestamp(event))
+		if err == nil && seen.Before(cutoff) {
+			continue
+		}
+		recent = append(recent, event)
+	}
+	return recent
+}
+
+// The time an event was last seen. Events written through events.k8s.io
+// only set eventTime, so fall back to it and then to the creation time.
+func eventTimestamp(event unstructured.Unstructured) string {
+	for _, field := range []string{"lastTimestamp", "eventTime"} {
+		if ts, _, _ := unstructured.NestedString(event.Object, field); ts != "" {
+			return ts
+		}
+	}
+	ts, _, _ := unstructured.NestedString(event.Object, "metadata", "creationTimestamp")
+	return ts
+}
+
+// Print each event to w as "Reason: message (xCount)"
+func printEvents(w io.Writer, events []unstructured.Unstructured) {
+	for _, event := range events {
+		reason, _, _ := unstructured.NestedString(event.Object, "reason")
+		message, _, _ := unstructured.NestedString(event.Object, "message")
+		count, found, _ := unstructured.NestedInt64(event.Object, "count")
+		if !found {
+			count = 1
+		}
+		fmt.Fprintf(w, "%s: %s (x%d)\n", reason, message, count)
+	}
+}
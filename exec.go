@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Split a command line into arguments like a POSIX shell, honoring single
+// and double quotes and backslash escapes
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// Find the first running pod matching the label selector
+func findRunningPod(ctx context.Context, clientset kubernetes.Interface, namespace, labelSelector string) (*corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+	return &pods.Items[0], nil
+}
+
+// Run a command in a pod container, streaming its output to stdout and
+// stderr, and return the command's exit status. When stdin is a terminal
+// the command gets a TTY and stdin, which merges its stderr into stdout.
+func execInPod(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, pod *corev1.Pod, container string, command []string) (int, error) {
+	if container == "" {
+		container = pod.Spec.Containers[0].Name
+	}
+	tty := isTerminal(os.Stdin)
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     tty,
+			Stdout:    true,
+			Stderr:    !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return 0, fmt.Errorf("exec in %q: %w", pod.Name, err)
+	}
+
+	options := remotecommand.StreamOptions{Stdout: os.Stdout, Tty: tty}
+	if tty {
+		options.Stdin = os.Stdin
+	} else {
+		options.Stderr = os.Stderr
+	}
+	err = executor.StreamWithContext(ctx, options)
+
+	// A command that ran and failed reports its exit status, not an error
+	var exitErr utilexec.ExitError
+	if stderrors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("exec in %q: %w", pod.Name, err)
+	}
+	return 0, nil
+}
+
+// Whether the file is an interactive terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+
+	"gitops/pkg/kube"
+)
+
+// List the resources named by --resource, --group and --version and print
+// them in the -o format, without reading or applying any manifest. Without
+// --version the preferred version of the group is used. A comma-separated
+// --resource, such as deployments,services,cronjobs.v1.batch, lists each
+// type in turn and prints the items together, with a kind column in -o
+// table. Like kubectl, each type is resource, resource.group or
+// resource.version.group. A type that fails to list is reported without
+// skipping the others.
+func runGet(ctx context.Context) error {
+	if resourceArg == "" {
+		return errors.New("get needs --resource, such as --resource deployments --group apps")
+	}
+	names := strings.Split(resourceArg, ",")
+	if len(names) > 1 && (groupArg != "" || versionArg != "") {
+		return errors.New("--group and --version only apply to a single --resource, name the group of each resource instead, such as deployments.apps,services")
+	}
+
+	config, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("loading cluster config: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := newDiscoveryClient(config)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+
+	var items []unstructured.Unstructured
+	var errs []error
+	for _, name := range names {
+		gvr := parseResourceArg(mapper, strings.TrimSpace(name))
+		if versionArg != "" {
+			gvr.Version = versionArg
+		}
+		if groupArg != "" {
+			gvr.Group = groupArg
+		}
+		// Each type gets its own deadline, so a slow one doesn't starve the rest
+		typeCtx, cancel := context.WithTimeout(ctx, timeout)
+		listed, err := getResources(typeCtx, dynamicClient, discoveryClient, mapper, gvr)
+		cancel()
+		if err != nil {
+			slog.Error("Listing resource failed", "resource", gvr.GroupResource().String(), "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		items = append(items, listed...)
+	}
+
+	tableColumns := columns
+	if len(names) > 1 && !containsString(columns, "kind") {
+		tableColumns = append([]string{"kind"}, columns...)
+	}
+	if output == outputTable {
+		errs = append(errs, printTable(os.Stdout, items, tableColumns))
+	} else {
+		errs = append(errs, printObjects(os.Stdout, items, output, allNS))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// List the resources of one type, resolved through the RESTMapper, in the
+// -n namespace or across all namespaces for -A and cluster-scoped types
+func getResources(ctx context.Context, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, mapper meta.RESTMapper, gvr schema.GroupVersionResource) ([]unstructured.Unstructured, error) {
+	if gvr.Version != "" {
+		if err := kube.ValidateResource(discoveryClient, gvr); err != nil {
+			return nil, err
+		}
+	}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving resource %q: %w", gvr.GroupResource(), err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %v to a resource: %w", gvk, err)
+	}
+
+	namespace := resolveNamespace("", contextNamespace())
+	if allNS || mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = metav1.NamespaceAll
+	}
+	return kube.GetResourcesDynamically(dynamicClient, ctx, mapping.Resource, namespace, listParams(selector, fieldSel))
+}
+
+// Parse resource.version.group the way kubectl does: a name with two or more
+// dots is tried as fully specified first, otherwise the part after the first
+// dot is the group and the version is left to the RESTMapper
+func parseResourceArg(mapper meta.RESTMapper, arg string) schema.GroupVersionResource {
+	fullySpecified, groupResource := schema.ParseResourceArg(arg)
+	if fullySpecified != nil {
+		if _, err := mapper.KindFor(*fullySpecified); err == nil {
+			return *fullySpecified
+		}
+	}
+	return groupResource.WithVersion("")
+}
+
+// Whether the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
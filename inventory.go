@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"gitops/pkg/kube"
+)
+
+// How long a single resource type may take to list during the inventory scan
+var scanTimeout = 10 * time.Second
+
+// Number of objects of a resource type across all namespaces, or the error
+// that kept the type from being listed
+type inventoryEntry struct {
+	Resource schema.GroupVersionResource
+	Count    int
+	Err      error
+}
+
+// List every namespaced resource type the cluster serves and count its
+// objects across all namespaces. Up to --parallelism types are listed at
+// once, each bounded by --scan-timeout so one slow API doesn't stall the scan.
+func scanAllNamespaced(ctx context.Context, config *rest.Config) ([]inventoryEntry, error) {
+	resources, err := listAPIResources(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var entries []inventoryEntry
+	var group errgroup.Group
+	group.SetLimit(max(parallelism, 1))
+	for _, resource := range resources {
+		if !resource.Namespaced || !hasVerb(resource, "list") {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Name}
+		group.Go(func() error {
+			typeCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+			defer cancel()
+
+			entry := inventoryEntry{Resource: gvr}
+			items, err := kube.GetResourcesDynamically(dynamicClient, typeCtx, gvr, metav1.NamespaceAll, listParams("", ""))
+			if err != nil {
+				slog.Warn("Listing resource failed", "resource", gvr.GroupResource().String(), "err", err)
+				entry.Err = err
+			}
+			entry.Count = len(items)
+
+			mu.Lock()
+			entries = append(entries, entry)
+			mu.Unlock()
+			return nil
+		})
+	}
+	group.Wait()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Resource.GroupResource().String() < entries[j].Resource.GroupResource().String()
+	})
+	return entries, nil
+}
+
+// Whether the resource type supports a verb such as "list"
+func hasVerb(resource metav1.APIResource, verb string) bool {
+	for _, v := range resource.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// Print the inventory as an aligned table
+func printInventory(entries []inventoryEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tCOUNT")
+	for _, entry := range entries {
+		count := fmt.Sprint(entry.Count)
+		if entry.Err != nil {
+			count = "<error>"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", entry.Resource.GroupResource().String(), count)
+	}
+	return w.Flush()
+}
+
+// Print how many objects of each namespaced resource type the cluster has
+func runInventory(ctx context.Context) error {
+	config, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("loading cluster config: %w", err)
+	}
+	entries, err := scanAllNamespaced(ctx, config)
+	if err != nil {
+		return err
+	}
+	return printInventory(entries)
+}
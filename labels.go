@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// A label or annotation change given as key=value, or as key- to remove it
+type metadataEdit struct {
+	key    string
+	value  string
+	remove bool
+}
+
+// Parse --set-label and --set-annotation values like kubectl label does
+func parseMetadataEdits(args []string) ([]metadataEdit, error) {
+	edits := make([]metadataEdit, 0, len(args))
+	for _, arg := range args {
+		if key, ok := strings.CutSuffix(arg, "-"); ok && key != "" && !strings.Contains(key, "=") {
+			edits = append(edits, metadataEdit{key: key, remove: true})
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q must be in key=value form, or key- to remove the key", arg)
+		}
+		edits = append(edits, metadataEdit{key: key, value: value})
+	}
+	return edits, nil
+}
+
+// Set a label on the named object
+func setLabel(ctx context.Context, resource dynamic.ResourceInterface, name, key, value string) error {
+	return setMetadataEntry(ctx, resource, name, "labels", key, &value)
+}
+
+// Remove a label from the named object
+func removeLabel(ctx context.Context, resource dynamic.ResourceInterface, name, key string) error {
+	return setMetadataEntry(ctx, resource, name, "labels", key, nil)
+}
+
+// Set an annotation on the named object
+func setAnnotation(ctx context.Context, resource dynamic.ResourceInterface, name, key, value string) error {
+	return setMetadataEntry(ctx, resource, name, "annotations", key, &value)
+}
+
+// Remove an annotation from the named object
+func removeAnnotation(ctx context.Context, resource dynamic.ResourceInterface, name, key string) error {
+	return setMetadataEntry(ctx, resource, name, "annotations", key, nil)
+}
+
+// Apply the label edits to the named object
+func editLabels(ctx context.Context, resource dynamic.ResourceInterface, name string, edits []metadataEdit) error {
+	for _, edit := range edits {
+		var err error
+		if edit.remove {
+			err = removeLabel(ctx, resource, name, edit.key)
+		} else {
+			err = setLabel(ctx, resource, name, edit.key, edit.value)
+		}
+		if err != nil {
+			return fmt.Errorf("label %q: %w", edit.key, err)
+		}
+	}
+	return nil
+}
+
+// Apply the annotation edits to the named object
+func editAnnotations(ctx context.Context, resource dynamic.ResourceInterface, name string, edits []metadataEdit) error {
+	for _, edit := range edits {
+		var err error
+		if edit.remove {
+			err = removeAnnotation(ctx, resource, name, edit.key)
+		} else {
+			err = setAnnotation(ctx, resource, name, edit.key, edit.value)
+		}
+		if err != nil {
+			return fmt.Errorf("annotation %q: %w", edit.key, err)
+		}
+	}
+	return nil
+}
+
+// Set or, with a nil value, delete a key of metadata.labels or
+// metadata.annotations and update the object
+func setMetadataEntry(ctx context.Context, resource dynamic.ResourceInterface, name, field, key string, value *string) error {
+	return retryOnConflict(func() error {
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		entries, _, err := unstructured.NestedStringMap(obj.Object, "metadata", field)
+		if err != nil {
+			return err
+		}
+		if entries == nil {
+			entries = make(map[string]string)
+		}
+		if value == nil {
+			delete(entries, key)
+		} else {
+			entries[key] = *value
+		}
+		if err := unstructured.SetNestedStringMap(obj.Object, entries, "metadata", field); err != nil {
+			return err
+		}
+
+		_, err = resource.Update(ctx, obj, metav1.UpdateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Send leveled logs to stderr so stdout only carries resource output
+func setupLogging(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Get the logs of a pod container, defaulting to the first container of the
+// pod when no container is named. With --since only the lines written within
+// that duration are returned.
+func podLogs(ctx context.Context, clientset kubernetes.Interface, namespace, name, container string) (string, error) {
+	if container == "" {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("getting pod %q: %w", name, err)
+		}
+		if len(pod.Spec.Containers) == 0 {
+			return "", fmt.Errorf("pod %q has no containers", name)
+		}
+		container = pod.Spec.Containers[0].Name
+	}
+
+	options := &corev1.PodLogOptions{Container: container}
+	if since > 0 {
+		// The API takes whole seconds, so round sub-second durations up
+		sinceSeconds := int64((since + time.Second - 1) / time.Second)
+		options.SinceSeconds = &sinceSeconds
+	}
+	logs, err := clientset.CoreV1().Pods(namespace).GetLogs(name, options).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting logs of %q container %q: %w", name, container, err)
+	}
+	return string(logs), nil
+}
+
+// Print the logs of every pod matching the label selector, each under a
+// "==> namespace/name <==" header
+func printPodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, labelSelector string) error {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return fmt.Errorf("listing pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		logs, err := podLogs(ctx, clientset, pod.Namespace, pod.Name, "")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("==> %s/%s <==\n%s", pod.Namespace, pod.Name, logs)
+	}
+	return nil
+}
@@ -3,181 +3,307 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/tools/clientcmd"
+	"github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/util/homedir"
+
+	"gitops/pkg/kube"
 )
 
-func main() {
-	// Load Kubernetes configuration from the default location ($HOME/.kube/config)
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
-	if err != nil {
-		panic(err.Error())
-	}
+// Manifest applied when no -f is given
+const defaultManifestURL = "https://raw.githubusercontent.com/Yuni-sa/social-hub-manifests/master/dev/golang-auth.yaml"
 
-	// Create a Kubernetes clientset and dynamic client
-	//clientset, err := kubernetes.NewForConfig(config)
-	//if err != nil {
-	//	panic(err.Error())
-	//}
-	dynamicClient := dynamic.NewForConfigOrDie(config)
+var (
+	kubeconfig   string
+	kubeContext  string
+	configMode   string
+	filenames    []string
+	serverSide   bool
+	forceApply   bool
+	dryRun       string
+	jqQuery      string
+	jqValues     bool
+	allNS        bool
+	waitReady    bool
+	waitTimeout  time.Duration
+	timeout      time.Duration
+	cascade      string
+	gracePeriod  int64
+	watchMode    bool
+	output       string
+	columns      []string
+	jqArgs       []string
+	selector     string
+	fieldSel     string
+	maxRetries   int
+	listRetries  int
+	retryDelay   time.Duration
+	logLevel     string
+	countBy      string
+	scale        map[string]int64
+	patchFile    string
+	patchType    string
+	rolloutStat  bool
+	export       bool
+	diffMode     bool
+	quiet        bool
+	createNS     bool
+	showEvents   bool
+	parallelism  int
+	asUser       string
+	asGroups     []string
+	caFile       string
+	insecureTLS  bool
+	token        string
+	server       string
+	kubeContexts []string
+	showLogs     bool
+	setLabels    []string
+	setAnnots    []string
+	statusOnly   bool
+	applyStatus  bool
+	prune        bool
+	pruneSel     string
+	strict       bool
+	mergeData    bool
+	qps          float32
+	burst        int
+	verify       bool
+	execCommand  string
+	forwardPorts []string
+	schemaCheck  bool
+	deleteMode   bool
+	deleteAll    bool
+	resourceArg  string
+	groupArg     string
+	versionArg   string
+	cacheDir     string
+	resetCache   bool
+	skipUnknown  bool
+	namespaceArg string
+	watchRollout bool
+	treeRoot     string
+	redact       bool
+	since        time.Duration
+	waitDelete   bool
+	planMode     string
+)
 
-	// Create a new scheme and add the necessary types
-	scheme := runtime.NewScheme()
-	metav1.AddToGroupVersion(scheme, metav1.SchemeGroupVersion)
+// Exit status of the --exec command, used as the exit status of the tool
+var execStatus int
 
-	// Create a new codec object that can handle the necessary types
-	codecs := serializer.NewCodecFactory(scheme)
+func init() {
+	pflag.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, defaults to KUBECONFIG or ~/.kube/config")
+	pflag.StringVar(&kubeContext, "context", "", "Name of the kubeconfig context to use")
+	pflag.StringSliceVar(&kubeContexts, "contexts", nil, "Kubeconfig contexts of the clusters to apply to one after another, such as dev,staging")
+	pflag.StringVar(&configMode, "config-mode", "", "Force how the cluster config is loaded: auto, in-cluster or kubeconfig")
+	pflag.StringVar(&asUser, "as", "", "User or service account to impersonate, such as system:serviceaccount:default:app")
+	pflag.StringArrayVar(&asGroups, "as-group", nil, "Group to impersonate, may be repeated")
+	pflag.StringVar(&caFile, "certificate-authority", "", "Path to a CA bundle for verifying the API server, overriding the kubeconfig's")
+	pflag.StringVar(&server, "server", "", "Address of the API server, such as https://1.2.3.4:6443, overriding the kubeconfig's")
+	pflag.StringVar(&token, "token", "", "Bearer token, such as a service account token, used instead of the kubeconfig's credentials")
+	pflag.BoolVar(&insecureTLS, "insecure-skip-tls-verify", false, "Don't verify the API server's certificate, for dev clusters with self-signed certs")
+	pflag.StringVarP(&namespaceArg, "namespace", "n", "", "Namespace of namespaced objects, overriding metadata.namespace and the context's namespace")
+	pflag.StringVar(&cacheDir, "cache-dir", filepath.Join(homedir.HomeDir(), ".kube", "cache"), "Directory of the discovery cache shared with kubectl, empty to only cache in memory")
+	pflag.BoolVar(&resetCache, "invalidate-cache", false, "Discard the cached discovery results before using them")
+	pflag.StringVar(&logLevel, "log-level", "info", "Minimum level of logs written to stderr: debug, info, warn or error")
+	pflag.BoolVarP(&quiet, "quiet", "q", false, "Only log errors and skip the container image listing, for piping resource output")
+	pflag.Float32Var(&qps, "qps", 50, "Maximum requests per second sent to the API server")
+	pflag.IntVar(&burst, "burst", 100, "Maximum burst of requests above --qps")
+	pflag.DurationVar(&timeout, "timeout", 30*time.Second, "Deadline of each API call or step of a run, --wait, --watch, --watch-rollout and --port-forward run longer")
+	pflag.IntVar(&maxRetries, "retries", 5, "How often to retry a mutating call that fails with a transient server error, or a scale, label or status update that conflicts")
+	pflag.IntVar(&listRetries, "list-retries", kube.DefaultListRetries, "How often to retry lists that fail because the API server is unavailable")
+	pflag.IntVar(&conflictRetries, "conflict-retries", conflictRetries, "How often to re-apply a manifest whose update conflicts with a concurrent change")
+	pflag.DurationVar(&retryDelay, "retry-delay", 500*time.Millisecond, "Initial delay between retries, doubled after each attempt")
+	pflag.StringArrayVarP(&filenames, "filename", "f", nil, "YAML or JSON manifest file, directory or http(s) URL to apply, \"-\" for stdin, may be repeated")
+	pflag.BoolVar(&strict, "strict", false, "Reject manifests that still contain {{ template syntax")
+	pflag.StringVar(&patchFile, "patch", "", "Patch file applied to the manifest objects instead of applying the manifests")
+	pflag.StringVar(&patchType, "patch-type", "merge", "Type of --patch: merge, json or strategic")
+	pflag.BoolVar(&createNS, "create-namespace", false, "Create the namespaces of applied objects when they don't exist")
+	pflag.IntVar(&parallelism, "parallelism", 1, "How many documents of the same install phase, or resource types of the inventory, are processed at once")
+	pflag.DurationVar(&scanTimeout, "scan-timeout", scanTimeout, "How long the inventory may take to list a single resource type")
+	pflag.BoolVar(&mergeData, "merge-data", false, "Merge the data of ConfigMaps and Secrets into the live object instead of replacing it")
+	pflag.BoolVar(&skipUnknown, "skip-unknown", false, "Exit successfully when manifests of kinds the cluster doesn't serve were skipped")
+	pflag.BoolVar(&schemaCheck, "validate", false, "Validate the manifests against the cluster's OpenAPI schema and apply nothing when one is invalid")
+	pflag.StringVar(&fieldManager, "field-manager", fieldManager, "Field manager name recorded in metadata.managedFields")
+	pflag.BoolVar(&serverSide, "server-side", false, "Apply manifests with server-side apply instead of create/update")
+	pflag.BoolVar(&forceApply, "force-conflicts", false, "Take ownership of conflicting fields during server-side apply")
+	pflag.StringVar(&dryRun, "dry-run", dryRunNone, "Only print what would be changed: none, client or server")
+	pflag.Lookup("dry-run").NoOptDefVal = dryRunClient
+	pflag.BoolVar(&prune, "prune", false, "Delete objects of the applied kinds that this tool manages but that are no longer in the manifests")
+	pflag.StringVar(&pruneSel, "prune-selector", "", "Label selector limiting the objects deleted by --prune")
+	pflag.StringVar(&treeRoot, "tree", "", "Print the objects owned by resource/name, such as deployment/ginx, instead of applying manifests")
+	pflag.BoolVar(&deleteMode, "delete", false, "Delete the --resource objects matched by -l instead of applying manifests")
+	pflag.BoolVar(&deleteAll, "all", false, "Allow --delete without a label selector, deleting every object of the resource")
+	pflag.StringVar(&resourceArg, "resource", "", "Resource to operate on without a manifest, such as deployments or deployments.apps")
+	pflag.StringVar(&groupArg, "group", "", "API group of the get --resource, such as apps, empty for the core group")
+	pflag.StringVar(&versionArg, "version", "", "API version of the get --resource, such as v1, defaults to the preferred version")
+	pflag.StringVar(&cascade, "cascade", "background", "How dependents are deleted: background, foreground or orphan")
+	pflag.Int64Var(&gracePeriod, "grace-period", -1, "Seconds given to the object to terminate, negative to use its default")
+	pflag.BoolVar(&diffMode, "diff", false, "Print a diff of each manifest against the live object instead of applying it")
+	pflag.BoolVar(&watchMode, "watch", false, "Watch the kinds in the manifests instead of applying them, until interrupted")
+	pflag.StringVar(&execCommand, "exec", "", "Command run in the first running pod matched by -l, such as \"sh -c 'cat /etc/hostname'\"")
+	pflag.StringSliceVar(&forwardPorts, "port-forward", nil, "Forward local:remote ports to the first running pod matched by -l until interrupted")
+	pflag.BoolVar(&showLogs, "logs", false, "Print the logs of the pods matched by -l in the namespaces of the applied objects")
+	pflag.BoolVar(&showEvents, "show-events", false, "Print the events of objects that failed to apply or become ready")
+	pflag.DurationVar(&since, "since", 0, "Only print events and logs newer than this duration, such as 10m")
+	pflag.BoolVar(&watchRollout, "watch-rollout", false, "Follow the rollout of applied Deployments, printing progress until it completes or stalls")
+	pflag.BoolVar(&rolloutStat, "rollout-status", false, "Print the status conditions of applied Deployments")
+	pflag.StringArrayVar(&setLabels, "set-label", nil, "Label applied objects with key=value, or remove a label with key-, may be repeated")
+	pflag.StringArrayVar(&setAnnots, "set-annotation", nil, "Annotate applied objects with key=value, or remove an annotation with key-, may be repeated")
+	pflag.StringToInt64Var(&scale, "scale", nil, "Scale applied workloads by name, as name=replicas")
+	pflag.BoolVar(&verify, "verify", false, "Read applied objects back and warn when they didn't materialize as expected")
+	pflag.BoolVar(&waitReady, "wait", false, "Wait for applied Deployments to become ready")
+	pflag.DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "How long to wait for a Deployment with --wait or a deletion with --wait-delete")
+	pflag.BoolVar(&waitDelete, "wait-delete", false, "Wait for deleted objects to be gone, logging the finalizers that keep them around")
+	pflag.BoolVar(&redact, "redact-secrets", false, "Replace Secret data values with <redacted> in printed output")
+	pflag.StringVarP(&output, "output", "o", "", "Output format for listed resources: json, jsonl, yaml, table or jsonpath=<template>")
+	pflag.StringSliceVar(&columns, "columns", []string{"name", "namespace"}, "Object paths printed as columns with -o table")
+	pflag.StringVarP(&selector, "selector", "l", "", "Label selector for listed resources, such as app=ginx")
+	pflag.StringVar(&fieldSel, "field-selector", "", "Field selector for listed resources, such as status.phase=Running")
+	pflag.BoolVar(&statusOnly, "status", false, "Print the status block of each applied object")
+	pflag.BoolVar(&applyStatus, "apply-status", false, "Write the status block of each manifest through the status subresource after applying it")
+	pflag.BoolVar(&export, "export", false, "Print each applied object as a clean YAML manifest")
+	pflag.StringVar(&countBy, "count-by", "", "Print counts of the listed resources grouped by a field path, such as status.phase")
+	pflag.StringVar(&jqQuery, "jq", "", "jq filter used to select the listed resources of each applied kind")
+	pflag.BoolVarP(&allNS, "all-namespaces", "A", false, "List and query resources across all namespaces")
+	pflag.StringArrayVar(&jqArgs, "arg", nil, "Bind a jq variable as key=value, referenced as $key in --jq, may be repeated")
+	pflag.BoolVar(&jqValues, "jq-values", false, "Print the values produced by --jq, prefixed with the namespace/name of their resource, instead of using it as a filter")
 
-	// Create a new YAML decoder using the scheme and codec object
-	decoder := codecs.UniversalDeserializer()
+	// Document the environment fallbacks under the flag list
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		pflag.PrintDefaults()
+		envs := make([]string, len(envFlags))
+		for i, name := range envFlags {
+			envs[i] = envName(name)
+		}
+		fmt.Fprintf(os.Stderr, "\nEnvironment variables %s set the matching flags.\nA flag on the command line wins over its variable, which wins over the built-in default.\n", strings.Join(envs, ", "))
+	}
+}
 
-	// Read the manifest file
-	//manifestPath := "my-deployment.yaml"
-	//manifestBytes, err := os.ReadFile(manifestPath)
+func main() {
+	pflag.Parse()
+	envErr := applyEnvFlags()
 
-	manifestURL := "https://raw.githubusercontent.com/Yuni-sa/social-hub-manifests/master/dev/golang-auth.yaml"
-	resp, err := http.Get(manifestURL)
-	if err != nil {
-		panic(err.Error())
+	if quiet {
+		logLevel = "error"
 	}
-	defer resp.Body.Close()
-	manifestBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		panic(err.Error())
+	if err := setupLogging(logLevel); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-
-	yamlDocs := strings.Split(string(manifestBytes), "---")
-	if err != nil {
-		panic(err.Error())
+	if envErr != nil {
+		slog.Error(envErr.Error())
+		os.Exit(1)
 	}
-	for _, yamlDoc := range yamlDocs {
-		if len(strings.TrimSpace(yamlDoc)) == 0 {
-			continue // Skip empty documents
-		}
-		// Decode the manifest into a runtime.Object
-		manifestObj := &unstructured.Unstructured{}
-		if _, _, err := decoder.Decode([]byte(yamlDoc), nil, manifestObj); err != nil {
-			panic(err.Error())
-		}
-
-		// Get the group, version, and kind from the manifest
-		gvk := manifestObj.GroupVersionKind()
-		namespace := manifestObj.GetNamespace()
-
-		//log.Println(namespace)
+	// Stop cleanly on Ctrl-C or SIGTERM, closing in-flight watches and waits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-		// If no version is specified, use the default values
-		if gvk.Version == "" {
-			gvk.Version = "v1"
-		}
-		// If no namespace is specified, use the default namespace
-		if namespace == "" {
-			namespace = "default"
-		}
-
-		// Get the resource from the dynamic client
-		resource := dynamicClient.Resource(gvk.GroupVersion().WithResource(strings.ToLower(gvk.Kind) + "s")).Namespace(namespace)
-		//log.Println(resource)
-
-		// Apply the manifest
-		_, err = resource.Create(context.Background(), manifestObj, metav1.CreateOptions{})
-		if err != nil {
-			log.Println(err.Error())
+	// Subcommands replace the default apply flow
+	var err error
+	switch pflag.Arg(0) {
+	case "":
+		if deleteMode {
+			err = runDeleteBySelector(ctx)
+		} else if treeRoot != "" {
+			err = runTree(ctx)
 		} else {
-			fmt.Printf("Manifest %q applied successfully.\n", manifestObj.GetName())
+			err = runClusters(ctx)
 		}
+	case "api-resources":
+		err = runAPIResources()
+	case "inventory":
+		err = runInventory(ctx)
+	case "get":
+		err = runGet(ctx)
+	case planOnly, planApply:
+		planMode = pflag.Arg(0)
+		err = runClusters(ctx)
+	default:
+		err = fmt.Errorf("unknown command %q, expected api-resources, apply, get, inventory or plan", pflag.Arg(0))
+	}
+	interrupted := ctx.Err() != nil
+	stop()
 
-		if gvk.Kind == "Deployment" || gvk.Kind == "Pod" {
-			fmt.Println(GetContainerImage(resource, context.Background()))
-		}
+	if err != nil {
+		slog.Error(err.Error())
+	}
+	if interrupted {
+		slog.Warn("Interrupted")
+		os.Exit(130)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	if execStatus != 0 {
+		os.Exit(execStatus)
+	}
+}
 
-		// Delete the manifest
-		err = resource.Delete(context.Background(), manifestObj.GetName(), metav1.DeleteOptions{})
+// Read the manifest files, or the example manifest when none are given
+func readManifests() ([][]byte, error) {
+	if len(filenames) == 0 {
+		filenames = []string{defaultManifestURL}
+	}
+	var yamlDocs [][]byte
+	for _, filename := range filenames {
+		docs, err := loadManifests(filename)
 		if err != nil {
-			log.Println(err.Error())
-		} else {
-			fmt.Printf("Manifest %q deleted successfully.\n", manifestObj.GetName())
+			return nil, fmt.Errorf("loading %s: %w", filename, err)
 		}
-
-		GetResources(resource, context.Background(), manifestObj, gvk)
-
-		// Print an empty line to create spacing
-		fmt.Println("")
+		yamlDocs = append(yamlDocs, docs...)
 	}
+	return yamlDocs, nil
 }
 
-// For every pod of the object in the default namespace print the first container image
-func GetContainerImage(resource dynamic.ResourceInterface, ctx context.Context) string {
-	//list, err := resource.List(context.Background(), metav1.ListOptions{FieldSelector: "metadata.name=golang-auth-deployment"})
-
-	list, err := resource.List(ctx, metav1.ListOptions{})
+// Apply the manifests to every --contexts cluster in turn, or to the
+// current cluster when no contexts are given
+func runClusters(ctx context.Context) error {
+	// Manifests are read once, stdin can't be read again for the next cluster
+	yamlDocs, err := readManifests()
 	if err != nil {
-		return (fmt.Sprintf(err.Error()))
-	} else {
-		for _, item := range list.Items {
-			// Extract the containers slice using unstructured.NestedSlice
-			containers, found, err := unstructured.NestedSlice(item.Object, "spec", "template", "spec", "containers")
-			if err != nil {
-				// Handle the error
-				return (fmt.Sprintf("Error extracting containers slice: %v\n", err))
-
-			}
-
-			if !found {
-				// Handle the case where the field is not found
-				return (fmt.Sprintf("Containers slice not found\n"))
-			}
-
-			// Get the first container in the slice
-			firstContainer, ok := containers[0].(map[string]interface{})
-			if !ok {
-				// Handle the case where the first item in the slice is not a map
-				return (fmt.Sprintf("First item in containers slice is not a map\n"))
-			}
-
-			// Extract the container image name from the first container
-			imageName, found, err := unstructured.NestedString(firstContainer, "image")
-			if err != nil {
-				// Handle the error
-				return (fmt.Sprintf("Error extracting container image name: %v\n", err))
-			}
-
-			if !found {
-				// Handle the case where the field is not found
-				return (fmt.Sprintf("Container image name field not found\n"))
-			}
-
-			// Print the image name
-			return (fmt.Sprintf(imageName))
+		return err
+	}
+	if len(kubeContexts) == 0 {
+		return run(ctx, yamlDocs)
+	}
 
+	var errs []error
+	for _, name := range kubeContexts {
+		kubeContext = name
+		slog.Info("Applying to cluster", "context", name)
+		if err := run(ctx, yamlDocs); err != nil {
+			slog.Error("Cluster failed", "context", name, "err", err)
+			errs = append(errs, fmt.Errorf("context %s: %w", name, err))
+		} else {
+			slog.Info("Cluster succeeded", "context", name)
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	return ""
+	slog.Info("Finished all clusters", "succeeded", len(kubeContexts)-len(errs), "failed", len(errs))
+	return utilerrors.NewAggregate(errs)
 }
 
-// Get the resources
-func GetResources(resource dynamic.ResourceInterface, ctx context.Context, manifestObj *unstructured.Unstructured, gvk schema.GroupVersionKind) {
-	_, err := resource.Get(ctx, manifestObj.GetName(), metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		fmt.Printf("%v %q not found in default namespace\n", gvk.Kind, manifestObj.GetName())
-	} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-		fmt.Printf("Error getting %v %v\n", gvk.Kind, statusError.ErrStatus.Message)
-	} else if err != nil {
-		panic(err.Error())
-	} else {
-		fmt.Printf("Found %q %v in default namespace\n", manifestObj.GetName(), gvk.Kind)
+// Print the resources served by the cluster
+func runAPIResources() error {
+	config, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("loading cluster config: %w", err)
+	}
+	resources, err := listAPIResources(config)
+	if err != nil {
+		return err
 	}
+	return printAPIResources(resources)
 }
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Load the YAML or JSON manifest documents, optionally gzipped, from a
+// file, from every *.yaml, *.yml and *.json file below a directory, from an
+// http(s) URL, or from stdin when path is "-"
+func loadManifests(path string) ([][]byte, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		data, err := fetchManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		return splitManifest(data)
+	}
+
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := splitManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			return nil, errors.New("no manifest documents found on stdin")
+		}
+		return docs, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return splitManifest(data)
+	}
+
+	var docs [][]byte
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(strings.TrimSuffix(file, ".gz")); ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fileDocs, err := splitManifest(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		docs = append(docs, fileDocs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// Content types accepted for remote manifests. Raw file hosts such as
+// raw.githubusercontent.com serve YAML as text/plain.
+var manifestContentTypes = map[string]bool{
+	"text/yaml":                true,
+	"text/x-yaml":              true,
+	"application/yaml":         true,
+	"application/x-yaml":       true,
+	"application/json":         true,
+	"text/plain":               true,
+	"application/octet-stream": true,
+}
+
+// Download a manifest over http(s), bounded by the --timeout flag
+func fetchManifest(url string) ([]byte, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", url, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !manifestContentTypes[mediaType] {
+			return nil, fmt.Errorf("fetching %s: unexpected content type %q", url, contentType)
+		}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Split a multi-document YAML or JSON stream into its documents, skipping
+// empty and comment-only ones. Separators inside block scalars and quoted
+// strings are left alone, and JSON arrays yield one document per element.
+func splitManifest(data []byte) ([][]byte, error) {
+	data, err := decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := checkUnrendered(data); err != nil {
+			return nil, err
+		}
+	}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+
+	var docs [][]byte
+	for {
+		var doc runtime.RawExtension
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(docs)+1, err)
+		}
+		// Documents without any content decode to null
+		if len(doc.Raw) == 0 {
+			continue
+		}
+		// Documents are decoded to JSON, so arrays of objects start with [
+		if doc.Raw[0] != '[' {
+			docs = append(docs, doc.Raw)
+			continue
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(doc.Raw, &items); err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(docs)+1, err)
+		}
+		for _, item := range items {
+			if string(item) != "null" {
+				docs = append(docs, item)
+			}
+		}
+	}
+}
+
+// Magic bytes at the start of gzip data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Decompress gzip data, such as a .yaml.gz file or a gzipped stream on stdin,
+// and return anything else unchanged
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing manifest: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing manifest: %w", err)
+	}
+	return decompressed, nil
+}
+
+// Reject Go template leftovers such as {{ .Values.image }}, which otherwise
+// fail with a cryptic YAML error
+func checkUnrendered(data []byte) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "{{") {
+			return fmt.Errorf("line %d contains template syntax %q, render the manifest first, for example with helm template", i+1, strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+// Check that a decoded document sets both apiVersion and kind
+func validateTypeMeta(doc []byte) error {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(doc, &typeMeta); err != nil {
+		return err
+	}
+	switch {
+	case typeMeta.APIVersion == "" && typeMeta.Kind == "":
+		return errors.New("missing apiVersion and kind")
+	case typeMeta.APIVersion == "":
+		return fmt.Errorf("%s is missing apiVersion", typeMeta.Kind)
+	case typeMeta.Kind == "":
+		return fmt.Errorf("%s object is missing kind", typeMeta.APIVersion)
+	}
+	return nil
+}
+
+// API versions such as v1, v1beta1 or v2alpha1
+var versionPattern = regexp.MustCompile(`^v\d+((alpha|beta)\d+)?$`)
+
+// Read an apiVersion that only names a group, such as "apps", as that group
+// with the version left out, the same as "apps/". Core kinds always name
+// their version, so "v1" stays as it is.
+func normalizeAPIVersion(obj *unstructured.Unstructured) {
+	apiVersion := obj.GetAPIVersion()
+	if !strings.Contains(apiVersion, "/") && !versionPattern.MatchString(apiVersion) {
+		obj.SetAPIVersion(apiVersion + "/")
+	}
+}
+
+// Kinds in the order they are applied, following Helm's install order so
+// that namespaces and CRDs exist before the objects that depend on them
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+}
+
+// Position of each kind in installOrder
+var kindPriority = func() map[string]int {
+	priority := make(map[string]int, len(installOrder))
+	for i, kind := range installOrder {
+		priority[kind] = i
+	}
+	return priority
+}()
+
+// Sort the manifests in install order. Unknown kinds, such as custom
+// resources, go last and the manifest order is kept within a kind.
+func sortManifestsByKind(manifests []*unstructured.Unstructured) {
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return priorityOf(manifests[i].GetKind()) < priorityOf(manifests[j].GetKind())
+	})
+}
+
+// Install priority of a kind, lower is applied first
+func priorityOf(kind string) int {
+	if priority, ok := kindPriority[kind]; ok {
+		return priority
+	}
+	return len(installOrder)
+}
+
+// Order manifests for deletion, the reverse of the install order. Objects
+// with an owner reference to another manifest in the batch are returned
+// separately, since the garbage collector removes them with their owner.
+func deletionOrder(manifests []*unstructured.Unstructured) ([]*unstructured.Unstructured, []*unstructured.Unstructured) {
+	var toDelete, owned []*unstructured.Unstructured
+	for _, obj := range manifests {
+		if ownedByBatch(obj, manifests) {
+			owned = append(owned, obj)
+		} else {
+			toDelete = append(toDelete, obj)
+		}
+	}
+
+	sort.SliceStable(toDelete, func(i, j int) bool {
+		return priorityOf(toDelete[i].GetKind()) > priorityOf(toDelete[j].GetKind())
+	})
+	return toDelete, owned
+}
+
+// Whether an owner reference of obj points at another manifest in the batch,
+// by UID when the manifest has one and by kind and name otherwise
+func ownedByBatch(obj *unstructured.Unstructured, batch []*unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		for _, other := range batch {
+			if other == obj || other.GetNamespace() != obj.GetNamespace() {
+				continue
+			}
+			if ref.UID != "" && ref.UID == other.GetUID() {
+				return true
+			}
+			if ref.Kind == other.GetKind() && ref.Name == other.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Group manifests sorted by sortManifestsByKind into phases of kinds with
+// the same install priority. The documents within a phase don't depend on
+// each other and can be applied concurrently.
+func installPhases(manifests []*unstructured.Unstructured) [][]*unstructured.Unstructured {
+	var phases [][]*unstructured.Unstructured
+	for i, obj := range manifests {
+		if i == 0 || priorityOf(obj.GetKind()) != priorityOf(manifests[i-1].GetKind()) {
+			phases = append(phases, nil)
+		}
+		phases[len(phases)-1] = append(phases[len(phases)-1], obj)
+	}
+	return phases
+}
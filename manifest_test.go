@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newObject(kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+// The kind/name of each object, in order
+func kindNames(objs []*unstructured.Unstructured) []string {
+	result := make([]string, len(objs))
+	for i, obj := range objs {
+		result[i] = obj.GetKind() + "/" + obj.GetName()
+	}
+	return result
+}
+
+func TestSortManifestsByKind(t *testing.T) {
+	manifests := []*unstructured.Unstructured{
+		newObject("Deployment", "app"),
+		newObject("Widget", "custom"),
+		newObject("Service", "app"),
+		newObject("ConfigMap", "config"),
+		newObject("CustomResourceDefinition", "widgets.example.com"),
+		newObject("Namespace", "apps"),
+	}
+	sortManifestsByKind(manifests)
+
+	want := []string{
+		"Namespace/apps",
+		"ConfigMap/config",
+		"CustomResourceDefinition/widgets.example.com",
+		"Service/app",
+		"Deployment/app",
+		"Widget/custom",
+	}
+	if got := kindNames(manifests); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSortManifestsByKindKeepsOrderWithinKind(t *testing.T) {
+	manifests := []*unstructured.Unstructured{
+		newObject("Widget", "b"),
+		newObject("Service", "second"),
+		newObject("Gadget", "a"),
+		newObject("Service", "first"),
+	}
+	sortManifestsByKind(manifests)
+
+	want := []string{"Service/second", "Service/first", "Widget/b", "Gadget/a"}
+	if got := kindNames(manifests); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// An object owned by the given owner, referenced by kind and name, or by
+// UID as well when the owner has one
+func ownedObject(kind, name string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+	obj := newObject(kind, name)
+	obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: owner.GetKind(), Name: owner.GetName(), UID: owner.GetUID()}})
+	return obj
+}
+
+func TestDeletionOrder(t *testing.T) {
+	deployment := newObject("Deployment", "app")
+	manifests := []*unstructured.Unstructured{
+		newObject("Namespace", "apps"),
+		newObject("ConfigMap", "config"),
+		newObject("Service", "app"),
+		deployment,
+		ownedObject("ReplicaSet", "app-123", deployment),
+	}
+
+	toDelete, owned := deletionOrder(manifests)
+	wantDelete := []string{"Deployment/app", "Service/app", "ConfigMap/config", "Namespace/apps"}
+	if got := kindNames(toDelete); !reflect.DeepEqual(got, wantDelete) {
+		t.Errorf("toDelete = %v, want %v", got, wantDelete)
+	}
+	if got := kindNames(owned); !reflect.DeepEqual(got, []string{"ReplicaSet/app-123"}) {
+		t.Errorf("owned = %v, want [ReplicaSet/app-123]", got)
+	}
+}
+
+func TestOwnedByBatch(t *testing.T) {
+	owner := newObject("Deployment", "app")
+	owner.SetUID(types.UID("uid-1"))
+
+	byUID := newObject("ReplicaSet", "by-uid")
+	byUID.SetOwnerReferences([]metav1.OwnerReference{{Kind: "Deployment", Name: "renamed", UID: "uid-1"}})
+
+	otherNamespace := ownedObject("ReplicaSet", "other-namespace", owner)
+	otherNamespace.SetNamespace("other")
+
+	outside := newObject("ReplicaSet", "outside")
+	outside.SetOwnerReferences([]metav1.OwnerReference{{Kind: "Deployment", Name: "elsewhere"}})
+
+	tests := []struct {
+		obj  *unstructured.Unstructured
+		want bool
+	}{
+		{ownedObject("ReplicaSet", "by-name", newObject("Deployment", "app")), true},
+		{byUID, true},
+		{otherNamespace, false},
+		{outside, false},
+		{newObject("ConfigMap", "unowned"), false},
+	}
+	for _, tt := range tests {
+		batch := []*unstructured.Unstructured{owner, tt.obj}
+		if got := ownedByBatch(tt.obj, batch); got != tt.want {
+			t.Errorf("ownedByBatch(%s) = %v, want %v", tt.obj.GetName(), got, tt.want)
+		}
+	}
+}
+
+func TestOwnedByBatchIgnoresSelfReference(t *testing.T) {
+	obj := newObject("Deployment", "app")
+	obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: "Deployment", Name: "app"}})
+	if ownedByBatch(obj, []*unstructured.Unstructured{obj}) {
+		t.Error("an object referencing itself counts as owned by the batch")
+	}
+}
+
+// The kind/name of each split document
+func docKindNames(t *testing.T, docs [][]byte) []string {
+	t.Helper()
+	result := make([]string, len(docs))
+	for i, doc := range docs {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(doc); err != nil {
+			t.Fatalf("document %d: %v", i+1, err)
+		}
+		result[i] = obj.GetKind() + "/" + obj.GetName()
+	}
+	return result
+}
+
+func TestSplitManifestSkipsCommentOnlyDocuments(t *testing.T) {
+	data := []byte(`# Rendered for the dev cluster
+# by the release pipeline
+---
+# Only a comment
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+`)
+	docs, err := splitManifest(data)
+	if err != nil {
+		t.Fatalf("splitManifest: %v", err)
+	}
+	want := []string{"ConfigMap/config", "Service/app"}
+	if got := docKindNames(t, docs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitManifestKeepsSeparatorInValue(t *testing.T) {
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: front-matter
+data:
+  post.md: |
+    ---
+    title: Hello
+    ---
+    Body
+  divider: "---"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`)
+	docs, err := splitManifest(data)
+	if err != nil {
+		t.Fatalf("splitManifest: %v", err)
+	}
+	want := []string{"ConfigMap/front-matter", "Service/app"}
+	if got := docKindNames(t, docs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	configMap := &unstructured.Unstructured{}
+	if err := configMap.UnmarshalJSON(docs[0]); err != nil {
+		t.Fatal(err)
+	}
+	values, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+	if want := "---\ntitle: Hello\n---\nBody\n"; values["post.md"] != want {
+		t.Errorf("post.md = %q, want %q", values["post.md"], want)
+	}
+	if values["divider"] != "---" {
+		t.Errorf("divider = %q, want ---", values["divider"])
+	}
+}
+
+func TestLoadManifestsGzip(t *testing.T) {
+	manifest := []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bundle.yaml.gz"), compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := splitManifest(manifest)
+	if err != nil {
+		t.Fatalf("splitManifest: %v", err)
+	}
+	// Both a file given directly and one found by walking the directory
+	for _, path := range []string{filepath.Join(dir, "bundle.yaml.gz"), dir} {
+		docs, err := loadManifests(path)
+		if err != nil {
+			t.Fatalf("loadManifests(%s): %v", path, err)
+		}
+		if !reflect.DeepEqual(docs, want) {
+			t.Errorf("loadManifests(%s) = %v, want %v", path, docKindNames(t, docs), docKindNames(t, want))
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+
+	"gitops/pkg/kube"
+)
+
+// Output formats accepted by -o
+const (
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// Prefix of -o jsonpath=<template>, which prints the listed items with a
+// kubectl JSONPath template
+const outputJSONPathPrefix = "jsonpath="
+
+// Print an object to w in the given output format, or as a Go value when no
+// format is set
+func printObject(w io.Writer, obj *unstructured.Unstructured, format string) error {
+	if redact {
+		obj = redactSecret(obj)
+	}
+	switch format {
+	case "":
+		fmt.Fprintf(w, "%+v\n", *obj)
+	case outputJSON:
+		data, err := json.MarshalIndent(obj.Object, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case outputJSONL:
+		// Encode writes compact JSON followed by a newline
+		return json.NewEncoder(w).Encode(obj.Object)
+	case outputYAML:
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "---\n%s", data)
+	default:
+		return fmt.Errorf("unknown output format %q, expected %s, %s, %s or %s", format, outputJSON, outputJSONL, outputYAML, outputTable)
+	}
+	return nil
+}
+
+// Print the items to w in the given output format. JSON prints a single
+// List of the items and JSON Lines one compact object per line. Without a
+// format each item is prefixed with its namespace when showNamespace is set.
+func printObjects(w io.Writer, items []unstructured.Unstructured, format string, showNamespace bool) error {
+	if format == outputTable {
+		return printTable(w, items, columns)
+	}
+	if redact {
+		redacted := make([]unstructured.Unstructured, len(items))
+		for i := range items {
+			redacted[i] = *redactSecret(&items[i])
+		}
+		items = redacted
+	}
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}, Items: items}
+	if expr, ok := strings.CutPrefix(format, outputJSONPathPrefix); ok {
+		return printJSONPath(w, list, expr)
+	}
+	if format == outputJSON {
+		return printObject(w, &unstructured.Unstructured{Object: list.UnstructuredContent()}, outputJSON)
+	}
+	for i := range items {
+		if showNamespace && format == "" {
+			fmt.Fprintf(w, "%s\t", items[i].GetNamespace())
+		}
+		if err := printObject(w, &items[i], format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Placeholder printed instead of Secret values with --redact-secrets
+const redactedValue = "<redacted>"
+
+// Return a copy of a v1 Secret with every data and stringData value replaced
+// by a placeholder, keeping the keys. Other objects are returned unchanged.
+func redactSecret(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj.GetAPIVersion() != "v1" || obj.GetKind() != "Secret" {
+		return obj
+	}
+	redacted := obj.DeepCopy()
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := redacted.Object[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range values {
+			values[key] = redactedValue
+		}
+	}
+	return redacted
+}
+
+// Validate an -o value, including the template of -o jsonpath=
+func validateOutput(format string) error {
+	if expr, ok := strings.CutPrefix(format, outputJSONPathPrefix); ok {
+		_, err := parseJSONPath(expr)
+		return err
+	}
+	if format != "" && format != outputJSON && format != outputJSONL && format != outputYAML && format != outputTable {
+		return fmt.Errorf("unknown output format %q, expected %s, %s, %s, %s or %s<template>", format, outputJSON, outputJSONL, outputYAML, outputTable, outputJSONPathPrefix)
+	}
+	return nil
+}
+
+// Compile a kubectl JSONPath template such as {.items[*].metadata.name}
+func parseJSONPath(expr string) (*jsonpath.JSONPath, error) {
+	template := jsonpath.New("output").AllowMissingKeys(true)
+	if err := template.Parse(expr); err != nil {
+		return nil, fmt.Errorf("parsing JSONPath template %q: %w", expr, err)
+	}
+	return template, nil
+}
+
+// Print the result of a JSONPath template run against the list
+func printJSONPath(w io.Writer, list *unstructured.UnstructuredList, expr string) error {
+	template, err := parseJSONPath(expr)
+	if err != nil {
+		return err
+	}
+	if err := template.Execute(w, list.UnstructuredContent()); err != nil {
+		return fmt.Errorf("executing JSONPath template %q: %w", expr, err)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// Print the items as an aligned table. Each column is a dotted path into the
+// object, with single names like "name" looked up under metadata except for
+// kind and apiVersion.
+func printTable(out io.Writer, items []unstructured.Unstructured, columns []string) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = strings.ToUpper(column)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, item := range items {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			value, err := columnValue(item.Object, column)
+			if err != nil {
+				return fmt.Errorf("column %q of %q: %w", column, item.GetName(), err)
+			}
+			row[i] = value
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// Resolve a table column path against an object, or "<none>" when it is unset
+func columnValue(obj map[string]interface{}, column string) (string, error) {
+	path := strings.Split(column, ".")
+	if len(path) == 1 && column != "kind" && column != "apiVersion" {
+		path = append([]string{"metadata"}, path...)
+	}
+
+	value, found, err := unstructured.NestedFieldNoCopy(obj, path...)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "<none>", nil
+	}
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Format a jq value as "<namespace>/<name>: <value>", or "<name>: <value>"
+// for cluster-scoped resources
+func formatJqResult(result kube.JqResult) string {
+	source := result.Name
+	if result.Namespace != "" {
+		source = result.Namespace + "/" + result.Name
+	}
+	return fmt.Sprintf("%s: %v", source, result.Value)
+}
+
+// Count the items by the value at a dotted path, such as "status.phase".
+// Items without the field are counted under "<none>".
+func summarize(items []unstructured.Unstructured, groupBy string) map[string]int {
+	counts := make(map[string]int)
+	for _, item := range items {
+		value, err := columnValue(item.Object, groupBy)
+		if err != nil {
+			value = "<none>"
+		}
+		counts[value]++
+	}
+	return counts
+}
+
+// Format counts as "Pending: 2, Running: 12", sorted by value
+func formatSummary(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Copy a live object without the server-populated fields, so it can be
+// reused as a manifest
+func exportResource(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	exported := obj.DeepCopy()
+	unstructured.RemoveNestedField(exported.Object, "status")
+	for _, field := range []string{"uid", "resourceVersion", "creationTimestamp", "generation", "managedFields"} {
+		unstructured.RemoveNestedField(exported.Object, "metadata", field)
+	}
+	return exported
+}
@@ -0,0 +1,58 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Get a resource by name, wrapping errors with the resource name. Requests
+// rejected by RBAC or authentication fail with an *AccessError.
+func GetResource(ctx context.Context, resource dynamic.ResourceInterface, name string) (*unstructured.Unstructured, error) {
+	obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+		return nil, NewAccessError("get", name, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting %q: %w", name, err)
+	}
+	return obj, nil
+}
+
+// A request the API server rejected as Forbidden or Unauthorized, with a
+// hint on how to get access
+type AccessError struct {
+	Verb     string
+	Resource string
+	Name     string
+	Reason   metav1.StatusReason
+	Err      error
+}
+
+// Describe the failed request from the status details of the API error
+func NewAccessError(verb, name string, err error) *AccessError {
+	accessErr := &AccessError{Verb: verb, Name: name, Reason: apierrors.ReasonForError(err), Err: err}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		details := status.Status().Details
+		accessErr.Resource = schema.GroupResource{Group: details.Group, Resource: details.Kind}.String()
+	}
+	return accessErr
+}
+
+func (e *AccessError) Error() string {
+	if e.Reason == metav1.StatusReasonUnauthorized {
+		return fmt.Sprintf("%s %q: not authenticated, log in again or refresh the kubeconfig credentials: %v", e.Verb, e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s %q: forbidden, grant the %q verb on %q with a Role or ClusterRole: %v", e.Verb, e.Name, e.Verb, e.Resource, e.Err)
+}
+
+func (e *AccessError) Unwrap() error {
+	return e.Err
+}
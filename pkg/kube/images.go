@@ -0,0 +1,103 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// For every object of the kind print the images of all of its containers,
+// prefixed with the object namespace when showNamespace is set
+func GetContainerImages(resource dynamic.ResourceInterface, ctx context.Context, showNamespace bool) ([]string, error) {
+	//list, err := resource.List(ctx, metav1.ListOptions{FieldSelector: "metadata.name=golang-auth-deployment"})
+
+	list, err := resource.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, item := range list.Items {
+		itemImages, err := ContainerImages(item.Object)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.GetName(), err)
+		}
+		for _, image := range itemImages {
+			line := fmt.Sprintf("%s\t%s", item.GetName(), image)
+			if showNamespace {
+				line = fmt.Sprintf("%s\t%s", item.GetNamespace(), line)
+			}
+			images = append(images, line)
+		}
+	}
+	return images, nil
+}
+
+// Path to the pod spec for each workload kind. Pods keep their containers
+// in spec, the other workloads in their pod template.
+var PodSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+}
+
+// Get the image of every init container and container as "<container>: <image>"
+func ContainerImages(obj map[string]interface{}) ([]string, error) {
+	kind, _, _ := unstructured.NestedString(obj, "kind")
+	podSpecPath, ok := PodSpecPaths[kind]
+	if !ok {
+		return nil, nil
+	}
+
+	var images []string
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, err := NestedMapSlice(obj, append(podSpecPath, field)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, container := range containers {
+			// Extract the container name and image
+			name, _, err := unstructured.NestedString(container, "name")
+			if err != nil {
+				return nil, fmt.Errorf("error extracting container name: %w", err)
+			}
+			image, found, err := unstructured.NestedString(container, "image")
+			if err != nil {
+				return nil, fmt.Errorf("error extracting container image name: %w", err)
+			}
+			if !found {
+				return nil, fmt.Errorf("container %q has no image field", name)
+			}
+			images = append(images, fmt.Sprintf("%s: %s", name, image))
+		}
+	}
+	return images, nil
+}
+
+// Get the slice of objects at path, such as a pod spec's containers. A
+// missing field gives an empty slice, a non-slice field or an item that is not
+// an object an error.
+func NestedMapSlice(obj map[string]interface{}, path ...string) ([]map[string]interface{}, error) {
+	field := strings.Join(path, ".")
+	items, _, err := unstructured.NestedSlice(obj, path...)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting %s slice: %w", field, err)
+	}
+
+	maps := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d in %s slice is not a map", i, field)
+		}
+		maps[i] = m
+	}
+	return maps, nil
+}
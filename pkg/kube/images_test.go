@@ -0,0 +1,99 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerImages(t *testing.T) {
+	podSpec := map[string]interface{}{
+		"initContainers": []interface{}{
+			map[string]interface{}{"name": "migrate", "image": "migrate:1.0"},
+		},
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app", "image": "app:2.1"},
+			map[string]interface{}{"name": "sidecar", "image": "envoy:1.29"},
+		},
+	}
+	want := []string{"migrate: migrate:1.0", "app: app:2.1", "sidecar: envoy:1.29"}
+
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+	}{
+		{"pod", map[string]interface{}{"kind": "Pod", "spec": podSpec}},
+		{"deployment", map[string]interface{}{
+			"kind": "Deployment",
+			"spec": map[string]interface{}{"template": map[string]interface{}{"spec": podSpec}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := ContainerImages(tt.obj)
+			if err != nil {
+				t.Fatalf("ContainerImages: %v", err)
+			}
+			if !reflect.DeepEqual(images, want) {
+				t.Errorf("got %q, want %q", images, want)
+			}
+		})
+	}
+}
+
+func TestContainerImagesMissingImage(t *testing.T) {
+	obj := map[string]interface{}{
+		"kind": "Pod",
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "app"}},
+		},
+	}
+	if _, err := ContainerImages(obj); err == nil {
+		t.Error("got no error for a container without an image")
+	}
+}
+
+func TestContainerImagesOtherKind(t *testing.T) {
+	images, err := ContainerImages(map[string]interface{}{"kind": "ConfigMap"})
+	if err != nil || images != nil {
+		t.Errorf("got %q, %v, want no images and no error", images, err)
+	}
+}
+
+func TestNestedMapSlice(t *testing.T) {
+	obj := map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True"},
+				map[string]interface{}{"type": "Progressing", "status": "True"},
+			},
+			"replicas":   int64(2),
+			"mixed":      []interface{}{map[string]interface{}{"type": "Available"}, "Progressing"},
+			"emptyItems": []interface{}{},
+		},
+	}
+
+	conditions, err := NestedMapSlice(obj, "status", "conditions")
+	if err != nil {
+		t.Fatalf("NestedMapSlice: %v", err)
+	}
+	want := []map[string]interface{}{
+		{"type": "Available", "status": "True"},
+		{"type": "Progressing", "status": "True"},
+	}
+	if !reflect.DeepEqual(conditions, want) {
+		t.Errorf("got %v, want %v", conditions, want)
+	}
+
+	for _, path := range [][]string{{"status", "missing"}, {"status", "emptyItems"}} {
+		items, err := NestedMapSlice(obj, path...)
+		if err != nil || len(items) != 0 {
+			t.Errorf("NestedMapSlice(%v) = %v, %v, want an empty slice", path, items, err)
+		}
+	}
+
+	for _, path := range [][]string{{"status", "replicas"}, {"status", "mixed"}} {
+		if _, err := NestedMapSlice(obj, path...); err == nil {
+			t.Errorf("NestedMapSlice(%v) gave no error", path)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// Resolve the version the API server prefers for a kind, for manifests
+// with an apiVersion such as "apps/" that leaves out the version
+func PreferredVersion(discoveryClient discovery.DiscoveryInterface, group, kind string) (string, error) {
+	groups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("discovering API groups: %w", err)
+	}
+	for _, apiGroup := range groups.Groups {
+		if apiGroup.Name != group {
+			continue
+		}
+		version := apiGroup.PreferredVersion.Version
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(apiGroup.PreferredVersion.GroupVersion)
+		if err != nil {
+			return "", fmt.Errorf("discovering resources of %s: %w", apiGroup.PreferredVersion.GroupVersion, err)
+		}
+		for _, resource := range resources.APIResources {
+			if resource.Kind == kind {
+				return version, nil
+			}
+		}
+		return "", fmt.Errorf("kind %s is not served by the preferred version %s", kind, apiGroup.PreferredVersion.GroupVersion)
+	}
+	return "", fmt.Errorf("API group %q is not served by the cluster", group)
+}
+
+// Split the manifests into those whose kind the cluster serves and those it
+// doesn't. Kinds defined by a CustomResourceDefinition in the same batch
+// count as served, since the CRD is applied first.
+func PartitionKnownKinds(mapper meta.RESTMapper, manifests []*unstructured.Unstructured) ([]*unstructured.Unstructured, []*unstructured.Unstructured) {
+	var known, unknown []*unstructured.Unstructured
+	for _, manifestObj := range manifests {
+		gvk := manifestObj.GroupVersionKind()
+		var versions []string
+		if gvk.Version != "" {
+			versions = append(versions, gvk.Version)
+		}
+		if _, err := mapper.RESTMapping(gvk.GroupKind(), versions...); meta.IsNoMatchError(err) && !definedInBatch(gvk.GroupKind(), manifests) {
+			unknown = append(unknown, manifestObj)
+			continue
+		}
+		known = append(known, manifestObj)
+	}
+	return known, unknown
+}
+
+// Whether a CustomResourceDefinition among the manifests defines the kind
+func definedInBatch(gk schema.GroupKind, manifests []*unstructured.Unstructured) bool {
+	for _, obj := range manifests {
+		if obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		if group == gk.Group && kind == gk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// The sorted, distinct "Kind.group" names of the objects
+func UnknownKinds(objs []*unstructured.Unstructured) []string {
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, obj := range objs {
+		kind := obj.GroupVersionKind().GroupKind().String()
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Resolve a resource name such as "deployments" or "deployments.apps" to
+// its preferred version and scope
+func MappingForResource(mapper meta.RESTMapper, resource string) (*meta.RESTMapping, error) {
+	gvk, err := mapper.KindFor(schema.ParseGroupResource(resource).WithVersion(""))
+	if err != nil {
+		return nil, fmt.Errorf("resolving resource %q: %w", resource, err)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %v to a resource: %w", gvk, err)
+	}
+	return mapping, nil
+}
+
+// Resolve the resource and scope of a kind. Callers share one mapper per run
+// so discovery isn't repeated for every manifest.
+func MappingForGVK(mapper meta.RESTMapper, gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %v to a resource: %w", gvk, err)
+	}
+	return mapping, nil
+}
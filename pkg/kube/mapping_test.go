@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// A fake discovery client serving apps/v1 ahead of apps/v1beta2, so v1 is
+// the preferred version of the apps group
+func newFakeDiscovery() *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment", Namespaced: true}},
+		},
+		{
+			GroupVersion: "apps/v1beta2",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true},
+				{Name: "legacywidgets", Kind: "LegacyWidget", Namespaced: true},
+			},
+		},
+	}}}
+}
+
+func TestPreferredVersion(t *testing.T) {
+	tests := []struct {
+		group, kind string
+		want        string
+	}{
+		{"apps", "Deployment", "v1"},
+		{"", "ConfigMap", "v1"},
+	}
+	for _, tt := range tests {
+		version, err := PreferredVersion(newFakeDiscovery(), tt.group, tt.kind)
+		if err != nil {
+			t.Fatalf("PreferredVersion(%q, %q): %v", tt.group, tt.kind, err)
+		}
+		if version != tt.want {
+			t.Errorf("PreferredVersion(%q, %q) = %q, want %q", tt.group, tt.kind, version, tt.want)
+		}
+	}
+}
+
+func TestPreferredVersionErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		group, kind string
+	}{
+		{"kind only in an older version", "apps", "LegacyWidget"},
+		{"unknown group", "example.com", "Widget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if version, err := PreferredVersion(newFakeDiscovery(), tt.group, tt.kind); err == nil {
+				t.Errorf("got version %q, want an error", version)
+			}
+		})
+	}
+}
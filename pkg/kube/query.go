@@ -0,0 +1,414 @@
+// Package kube lists Kubernetes resources of any type through the dynamic
+// client and queries them with jq.
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// ErrNonBooleanFilter is returned when a jq query used as a filter outputs
+// something other than true or false, which usually means a projection such
+// as ".metadata.name" was passed where a filter was expected
+var ErrNonBooleanFilter = errors.New("jq filter returned a non-boolean value")
+
+// ErrQueryParse is returned when a jq query or a selector can't be parsed
+var ErrQueryParse = errors.New("invalid query")
+
+// ErrListFailed is returned when the API server fails to list resources
+var ErrListFailed = errors.New("listing resources failed")
+
+// ErrConversion is returned when a resource can't be converted to or from
+// the JSON values jq works on
+var ErrConversion = errors.New("converting resource failed")
+
+// Get the client for a resource type in a namespace, or across the cluster
+// when the namespace is empty
+func NamespacedResource(dynamicClient dynamic.Interface, resourceId schema.GroupVersionResource, namespace string) dynamic.ResourceInterface {
+	if namespace == "" {
+		return dynamicClient.Resource(resourceId)
+	}
+	return dynamicClient.Resource(resourceId).Namespace(namespace)
+}
+
+// Check that the API server serves a resource type, so a mistyped name is
+// reported clearly instead of failing at List time
+func ValidateResource(discoveryClient discovery.DiscoveryInterface, resourceId schema.GroupVersionResource) error {
+	groupVersion := resourceId.GroupVersion().String()
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("group/version %q not found", groupVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == resourceId.Resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("resource %q not found in group/version %q", resourceId.Resource, groupVersion)
+}
+
+// Number of items fetched per List request when paging through resources
+const DefaultPageSize int64 = 500
+
+// How often a List that fails with 503 or 500 is retried by default
+const DefaultListRetries = 3
+
+// How GetResourcesDynamically lists resources. A non-empty LabelSelector,
+// such as "app=ginx", or FieldSelector, such as "status.phase=Running", is
+// evaluated by the API server. Items are fetched in pages of PageSize, or all
+// at once when it is not positive, and each page is retried Retries times.
+type ListParams struct {
+	LabelSelector string
+	FieldSelector string
+	PageSize      int64
+	Retries       int
+}
+
+// Paged listing of everything with the default retries, for the jq helpers
+var defaultListParams = ListParams{PageSize: DefaultPageSize, Retries: DefaultListRetries}
+
+// List the resources of a given type in a namespace. An empty namespace
+// lists cluster-scoped resources such as Nodes or ClusterRoles. Callers that
+// take the type from user input check it with ValidateResource first.
+func GetResourcesDynamically(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, params ListParams) ([]unstructured.Unstructured, error) {
+	if _, err := fields.ParseSelector(params.FieldSelector); err != nil {
+		return nil, fmt.Errorf("%w: invalid field selector %q: %w", ErrQueryParse, params.FieldSelector, err)
+	}
+	resource := NamespacedResource(dynamicClient, resourceId, namespace)
+
+	var items []unstructured.Unstructured
+	options := metav1.ListOptions{LabelSelector: params.LabelSelector, FieldSelector: params.FieldSelector}
+	if params.PageSize > 0 {
+		options.Limit = params.PageSize
+	}
+	for {
+		list, err := ListWithRetry(ctx, resource, options, params.Retries)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrListFailed, resourceId.Resource, err)
+		}
+		items = append(items, list.Items...)
+
+		options.Continue = list.GetContinue()
+		if options.Continue == "" {
+			return items, nil
+		}
+	}
+}
+
+// List a page of resources, retrying up to retries times with jittered
+// backoff while the API server is unavailable or reports an internal error
+func ListWithRetry(ctx context.Context, resource dynamic.ResourceInterface, options metav1.ListOptions, retries int) (*unstructured.UnstructuredList, error) {
+	backoff := wait.Backoff{Duration: 200 * time.Millisecond, Factor: 2, Jitter: 0.5, Steps: retries + 1}
+	for attempt := 1; ; attempt++ {
+		list, err := resource.List(ctx, options)
+		if err == nil || !(apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err)) || attempt > retries {
+			return list, err
+		}
+
+		delay := backoff.Step()
+		slog.Debug("Retrying list", "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// List the resources of a given type for which the jq filter evaluates to true.
+// A filter that outputs a non-boolean value fails with ErrNonBooleanFilter,
+// use EvaluateJq to collect the values of projection queries instead. With
+// limitToFirst set, evaluation stops at the first match, for checks that only
+// need to know whether any resource matches.
+func GetResourcesByJq(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, jq string, limitToFirst bool) ([]unstructured.Unstructured, error) {
+	code, err := CompileJq(jq)
+	if err != nil {
+		return nil, err
+	}
+	if !limitToFirst {
+		return GetResourcesByJqCode(dynamicClient, ctx, resourceId, namespace, code)
+	}
+
+	items, err := GetResourcesDynamically(dynamicClient, ctx, resourceId, namespace, defaultListParams)
+	if err != nil {
+		return nil, err
+	}
+	return filterByJq(items, code, 1)
+}
+
+// Parse and compile a jq program once so it can be evaluated repeatedly.
+// The program may reference the given variables, such as "$key", whose
+// values are supplied when the code is run.
+func CompileJq(jq string, variables ...string) (*gojq.Code, error) {
+	query, err := gojq.Parse(jq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrQueryParse, err)
+	}
+	code, err := gojq.Compile(query, gojq.WithVariables(variables))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrQueryParse, err)
+	}
+	return code, nil
+}
+
+// List the resources of a given type for which a compiled jq filter
+// evaluates to true, binding values to the variables it was compiled with
+func GetResourcesByJqCode(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, code *gojq.Code, values ...interface{}) ([]unstructured.Unstructured, error) {
+	items, err := GetResourcesDynamically(dynamicClient, ctx, resourceId, namespace, defaultListParams)
+	if err != nil {
+		return nil, err
+	}
+	return FilterByJq(items, code, values...)
+}
+
+// Keep the already listed resources for which a compiled jq filter evaluates
+// to true, failing with ErrNonBooleanFilter on non-boolean output
+func FilterByJq(items []unstructured.Unstructured, code *gojq.Code, values ...interface{}) ([]unstructured.Unstructured, error) {
+	return filterByJq(items, code, 0, values...)
+}
+
+// Keep the resources matching a compiled jq filter, returning once limit
+// resources matched. A limit of 0 evaluates every item.
+func filterByJq(items []unstructured.Unstructured, code *gojq.Code, limit int, values ...interface{}) ([]unstructured.Unstructured, error) {
+	resources := make([]unstructured.Unstructured, 0)
+	for _, item := range items {
+		matched, err := matchesJq(code, item, values...)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			resources = append(resources, item)
+			if len(resources) == limit {
+				break
+			}
+		}
+	}
+	return resources, nil
+}
+
+// List the resources of a given type once and evaluate several named jq
+// filters against them, returning the matches for each query name
+func GetResourcesByJqMulti(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, queries map[string]string) (map[string][]unstructured.Unstructured, error) {
+	compiled := make(map[string]*gojq.Code, len(queries))
+	for name, jq := range queries {
+		code, err := CompileJq(jq)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", name, err)
+		}
+		compiled[name] = code
+	}
+
+	items, err := GetResourcesDynamically(dynamicClient, ctx, resourceId, namespace, defaultListParams)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string][]unstructured.Unstructured, len(compiled))
+	for name, code := range compiled {
+		resources[name] = make([]unstructured.Unstructured, 0)
+		for _, item := range items {
+			matched, err := matchesJq(code, item)
+			if err != nil {
+				return nil, fmt.Errorf("query %q: %w", name, err)
+			}
+			if matched {
+				resources[name] = append(resources[name], item)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Evaluate a jq filter against a resource, reporting whether it returned true.
+// Any output other than a boolean is an ErrNonBooleanFilter.
+func matchesJq(code *gojq.Code, item unstructured.Unstructured, values ...interface{}) (bool, error) {
+	// Convert object to raw JSON
+	var rawJson interface{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &rawJson)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q: %w", ErrConversion, item.GetName(), err)
+	}
+
+	// Evaluate jq against JSON
+	matched := false
+	iter := code.Run(rawJson, values...)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := result.(error); ok {
+			if err != nil {
+				return false, err
+			}
+		} else {
+			boolResult, ok := result.(bool)
+			if !ok {
+				return false, fmt.Errorf("%w: got %T for %q", ErrNonBooleanFilter, result, item.GetName())
+			}
+			if boolResult {
+				matched = true
+			}
+		}
+	}
+	return matched, nil
+}
+
+// Collect every value the jq program outputs across the resources of a given type
+func EvaluateJq(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, jq string) ([]interface{}, error) {
+	code, err := CompileJq(jq)
+	if err != nil {
+		return nil, err
+	}
+	return EvaluateJqCode(dynamicClient, ctx, resourceId, namespace, code)
+}
+
+// Collect every value a compiled jq program outputs across the resources of a given type
+func EvaluateJqCode(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, code *gojq.Code, values ...interface{}) ([]interface{}, error) {
+	items, err := GetResourcesDynamically(dynamicClient, ctx, resourceId, namespace, defaultListParams)
+	if err != nil {
+		return nil, err
+	}
+	return EvaluateJqItems(items, code, values...)
+}
+
+// Collect every value a compiled jq program outputs across already listed resources
+func EvaluateJqItems(items []unstructured.Unstructured, code *gojq.Code, values ...interface{}) ([]interface{}, error) {
+	sourced, err := EvaluateJqItemsWithSource(items, code, values...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(sourced))
+	for i, result := range sourced {
+		results[i] = result.Value
+	}
+	return results, nil
+}
+
+// A value output by a jq program and the resource it was evaluated against
+type JqResult struct {
+	Name      string
+	Namespace string
+	Value     interface{}
+}
+
+// Collect every value a compiled jq program outputs across already listed
+// resources, keeping the resource that produced each value
+func EvaluateJqItemsWithSource(items []unstructured.Unstructured, code *gojq.Code, values ...interface{}) ([]JqResult, error) {
+	results := make([]JqResult, 0)
+	for _, item := range items {
+		// Convert object to raw JSON
+		var rawJson interface{}
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &rawJson)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrConversion, item.GetName(), err)
+		}
+
+		// Evaluate jq against JSON and keep whatever it produces
+		iter := code.Run(rawJson, values...)
+		for {
+			result, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := result.(error); ok {
+				return nil, err
+			}
+			results = append(results, JqResult{Name: item.GetName(), Namespace: item.GetNamespace(), Value: result})
+		}
+	}
+	return results, nil
+}
+
+// Run a jq program that rewrites each resource of a given type, such as
+// ".spec.replicas = 3", and update the resources with the result. The
+// program must output exactly one object per resource.
+func TransformResourcesByJq(dynamicClient dynamic.Interface, ctx context.Context, resourceId schema.GroupVersionResource, namespace string, jq string) ([]unstructured.Unstructured, error) {
+	code, err := CompileJq(jq)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := GetResourcesDynamically(dynamicClient, ctx, resourceId, namespace, defaultListParams)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := NamespacedResource(dynamicClient, resourceId, namespace)
+	updated := make([]unstructured.Unstructured, 0, len(items))
+	for _, item := range items {
+		// Convert object to raw JSON
+		var rawJson interface{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &rawJson)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrConversion, item.GetName(), err)
+		}
+
+		// Evaluate jq against JSON, expecting a single object back
+		var outputs []interface{}
+		iter := code.Run(rawJson)
+		for {
+			result, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := result.(error); ok {
+				return nil, err
+			}
+			outputs = append(outputs, result)
+		}
+		if len(outputs) != 1 {
+			return nil, fmt.Errorf("transform of %q returned %d values, expected one object", item.GetName(), len(outputs))
+		}
+		result, ok := outputs[0].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("transform of %q returned %T, expected an object", item.GetName(), outputs[0])
+		}
+
+		// Convert the result back to a resource and store it
+		var obj unstructured.Unstructured
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(result, &obj); err != nil {
+			return nil, fmt.Errorf("%w: result of %q: %w", ErrConversion, item.GetName(), err)
+		}
+		next, err := resource.Update(ctx, &obj, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("updating %q: %w", item.GetName(), err)
+		}
+		updated = append(updated, *next)
+	}
+	return updated, nil
+}
+
+// Parse "key=value" pairs into jq variable names and their values
+func ParseJqArgs(args []string) ([]string, []interface{}, error) {
+	names := make([]string, 0, len(args))
+	values := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("jq argument %q must be in key=value form", arg)
+		}
+		names = append(names, "$"+strings.TrimPrefix(name, "$"))
+		values = append(values, value)
+	}
+	return names, values, nil
+}
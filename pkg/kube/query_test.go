@@ -0,0 +1,161 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func newDeployment(name, app string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{"app": app},
+		},
+	}}
+}
+
+// A fake dynamic client serving the given Deployments
+func newFakeClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{deploymentsGVR: "DeploymentList"}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+func names(items []unstructured.Unstructured) []string {
+	result := make([]string, len(items))
+	for i, item := range items {
+		result[i] = item.GetName()
+	}
+	return result
+}
+
+func TestGetResourcesByJqMatchesLabel(t *testing.T) {
+	client := newFakeClient(newDeployment("ginx", "ginx"), newDeployment("auth", "auth"))
+
+	items, err := GetResourcesByJq(client, context.Background(), deploymentsGVR, "default", `.metadata.labels.app == "ginx"`, false)
+	if err != nil {
+		t.Fatalf("GetResourcesByJq: %v", err)
+	}
+	if got := names(items); len(got) != 1 || got[0] != "ginx" {
+		t.Errorf("got %v, want [ginx]", got)
+	}
+}
+
+func TestGetResourcesDynamicallyLabelSelector(t *testing.T) {
+	client := newFakeClient(newDeployment("ginx", "ginx"), newDeployment("auth", "auth"))
+
+	items, err := GetResourcesDynamically(client, context.Background(), deploymentsGVR, "default", ListParams{LabelSelector: "app=auth", PageSize: DefaultPageSize})
+	if err != nil {
+		t.Fatalf("GetResourcesDynamically: %v", err)
+	}
+	if got := names(items); len(got) != 1 || got[0] != "auth" {
+		t.Errorf("got %v, want [auth]", got)
+	}
+}
+
+func TestGetResourcesByJqInvalidQuery(t *testing.T) {
+	client := newFakeClient(newDeployment("ginx", "ginx"))
+
+	_, err := GetResourcesByJq(client, context.Background(), deploymentsGVR, "default", `.metadata.labels[`, false)
+	if !errors.Is(err, ErrQueryParse) {
+		t.Errorf("err = %v, want ErrQueryParse", err)
+	}
+}
+
+// n listed Deployments, every tenth of them labelled app=ginx
+func newDeployments(n int) []unstructured.Unstructured {
+	items := make([]unstructured.Unstructured, n)
+	for i := range items {
+		app := "auth"
+		if i%10 == 0 {
+			app = "ginx"
+		}
+		items[i] = *newDeployment(fmt.Sprintf("app-%d", i), app)
+	}
+	return items
+}
+
+// Query evaluated once per manifest document by the run loop
+const benchmarkQuery = `.metadata.labels.app == "ginx" and (.metadata.name | startswith("app-"))`
+
+// Compiling the query for every manifest document, as callers did before
+// CompileJq
+func BenchmarkFilterByJqCompileEachDocument(b *testing.B) {
+	items := newDeployments(10)
+	for i := 0; i < b.N; i++ {
+		for doc := 0; doc < 20; doc++ {
+			code, err := CompileJq(benchmarkQuery)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := FilterByJq(items, code); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Compiling the query once and evaluating the code for every document
+func BenchmarkFilterByJqCompileOnce(b *testing.B) {
+	items := newDeployments(10)
+	code, err := CompileJq(benchmarkQuery)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for doc := 0; doc < 20; doc++ {
+			if _, err := FilterByJq(items, code); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestGetResourcesByJqLimitToFirst(t *testing.T) {
+	client := newFakeClient(newDeployment("ginx", "ginx"), newDeployment("ginx-canary", "ginx"), newDeployment("auth", "auth"))
+
+	items, err := GetResourcesByJq(client, context.Background(), deploymentsGVR, "default", `.metadata.labels.app == "ginx"`, true)
+	if err != nil {
+		t.Fatalf("GetResourcesByJq: %v", err)
+	}
+	if len(items) != 1 || items[0].GetLabels()["app"] != "ginx" {
+		t.Errorf("got %v, want a single ginx Deployment", names(items))
+	}
+}
+
+// Existence check on a large list whose first item matches, evaluating the
+// filter against every item
+func BenchmarkFilterByJqLargeListAll(b *testing.B) {
+	benchmarkFilterByJqLargeList(b, 0)
+}
+
+// The same check returning at the first match, as with limitToFirst
+func BenchmarkFilterByJqLargeListFirst(b *testing.B) {
+	benchmarkFilterByJqLargeList(b, 1)
+}
+
+func benchmarkFilterByJqLargeList(b *testing.B, limit int) {
+	items := newDeployments(10000)
+	code, err := CompileJq(`.metadata.labels.app == "ginx"`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := filterByJq(items, code, limit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
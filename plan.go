@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// What applying the manifests would do to an object
+type planAction string
+
+// Actions of a plan, printed in the ACTION column
+const (
+	planCreate    planAction = "create"
+	planUpdate    planAction = "update"
+	planUnchanged planAction = "unchanged"
+	planDelete    planAction = "delete"
+)
+
+// Modes of the plan and apply commands
+const (
+	planOnly  = "plan"
+	planApply = "apply"
+)
+
+// A planned change to one object
+type planEntry struct {
+	action    planAction
+	kind      string
+	namespace string
+	name      string
+}
+
+// Compare the manifest against its live object. Missing objects are
+// created, and objects whose live state already holds every field of the
+// manifest are unchanged.
+func planManifest(ctx context.Context, resource dynamic.ResourceInterface, manifest *unstructured.Unstructured) (planAction, error) {
+	live, err := kube.GetResource(ctx, resource, manifest.GetName())
+	if errors.IsNotFound(err) {
+		return planCreate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Compare the manifest as it would be applied, with the managed-by label
+	desired := exportResource(manifest)
+	setManagedBy(desired)
+	if containsFields(live.Object, desired.Object) {
+		return planUnchanged, nil
+	}
+	return planUpdate, nil
+}
+
+// Whether live holds every field of want with an equal value. Fields only
+// set in live, such as defaults filled in by the API server, are ignored,
+// while lists must have the same length.
+func containsFields(live, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !containsFields(liveMap[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		liveSlice, ok := live.([]interface{})
+		if !ok || len(liveSlice) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !containsFields(liveSlice[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(live, want)
+	}
+}
+
+// Print the plan as a table followed by a count of each action
+func printPlan(entries []planEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tKIND\tNAMESPACE\tNAME")
+	counts := make(map[planAction]int)
+	for _, entry := range entries {
+		namespace := entry.namespace
+		if namespace == "" {
+			namespace = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.action, entry.kind, namespace, entry.name)
+		counts[entry.action]++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Plan: %d to create, %d to update, %d unchanged, %d to delete\n",
+		counts[planCreate], counts[planUpdate], counts[planUnchanged], counts[planDelete])
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Forward local ports to a pod, given as "local:remote" or a single port
+// used for both, until the context is done
+func portForward(ctx context.Context, config *rest.Config, namespace, podName string, ports []string) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return err
+	}
+
+	url := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	// Closing stop ends the forwarding
+	stop := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+
+	// Progress messages go to stderr with the logs
+	forwarder, err := portforward.New(dialer, ports, stop, nil, os.Stderr, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("port-forward to %q: %w", podName, err)
+	}
+	if err := forwarder.ForwardPorts(); err != nil {
+		return fmt.Errorf("port-forward to %q: %w", podName, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// Label marking the objects applied by this tool, the only ones --prune deletes
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// Label the object as managed by this tool before it is applied
+func setManagedBy(obj *unstructured.Unstructured) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[managedByLabel] = appName
+	obj.SetLabels(labels)
+}
+
+// A resource type and namespace that received applied manifests
+type pruneScope struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+// Delete the objects of the scope that this tool manages and that match the
+// selector, except the ones named in keep. Returns the pruned names.
+func pruneResources(ctx context.Context, dynamicClient dynamic.Interface, scope pruneScope, selector string, keep map[string]bool, options metav1.DeleteOptions) ([]string, error) {
+	items, err := pruneCandidates(ctx, dynamicClient, scope, selector, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := kube.NamespacedResource(dynamicClient, scope.gvr, scope.namespace)
+	var pruned []string
+	for _, item := range items {
+		if dryRun == dryRunClient {
+			slog.Info("Resource would be pruned (dry run)", "resource", scope.gvr.Resource, "name", item.GetName())
+			continue
+		}
+		if err := retryTransient(func() error {
+			return resource.Delete(ctx, item.GetName(), options)
+		}); err != nil {
+			return pruned, fmt.Errorf("pruning %s %q: %w", scope.gvr.Resource, item.GetName(), err)
+		}
+		pruned = append(pruned, item.GetName())
+	}
+	return pruned, nil
+}
+
+// Managed objects of the scope that --prune would delete, leaving out the
+// ones named in keep and owned objects, which the garbage collector removes
+// with their owner
+func pruneCandidates(ctx context.Context, dynamicClient dynamic.Interface, scope pruneScope, selector string, keep map[string]bool) ([]unstructured.Unstructured, error) {
+	managed := managedByLabel + "=" + appName
+	if selector != "" {
+		managed += "," + selector
+	}
+	items, err := kube.GetResourcesDynamically(dynamicClient, ctx, scope.gvr, scope.namespace, listParams(managed, ""))
+	if err != nil {
+		return nil, fmt.Errorf("listing %s to prune: %w", scope.gvr.Resource, err)
+	}
+
+	var candidates []unstructured.Unstructured
+	for _, item := range items {
+		if keep[item.GetName()] || len(item.GetOwnerReferences()) > 0 {
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	return candidates, nil
+}
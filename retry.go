@@ -0,0 +1,58 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"gitops/pkg/kube"
+)
+
+// Backoff between attempts of a mutating call, configured by --retries and
+// --retry-delay
+func retryBackoff() wait.Backoff {
+	// Always make at least one attempt
+	steps := maxRetries + 1
+	if steps < 1 {
+		steps = 1
+	}
+	return wait.Backoff{
+		Duration: retryDelay,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    steps,
+	}
+}
+
+// Paged listing with the given selectors, retried --list-retries times while
+// the API server is unavailable
+func listParams(labelSelector, fieldSelector string) kube.ListParams {
+	return kube.ListParams{
+		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
+		PageSize:      kube.DefaultPageSize,
+		Retries:       listRetries,
+	}
+}
+
+// Whether an error is the API server being overloaded or slow to respond
+func isTransient(err error) bool {
+	return errors.IsServerTimeout(err) || errors.IsTooManyRequests(err) || errors.IsTimeout(err)
+}
+
+// Whether an error is a resourceVersion conflict or a transient server error
+func isConflictOrTransient(err error) bool {
+	return errors.IsConflict(err) || isTransient(err)
+}
+
+// Retry fn with exponential backoff while it fails with transient server errors
+func retryTransient(fn func() error) error {
+	return retry.OnError(retryBackoff(), isTransient, fn)
+}
+
+// Retry fn on resourceVersion conflicts as well as on transient server errors,
+// in a single loop sharing the --retries budget. fn must re-read the object
+// it mutates so each attempt uses a fresh version.
+func retryOnConflict(fn func() error) error {
+	return retry.OnError(retryBackoff(), isConflictOrTransient, fn)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// Print each status condition of a Deployment to w as "Type=Status Reason"
+func printConditions(w io.Writer, obj *unstructured.Unstructured) error {
+	conditions, err := kube.NestedMapSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return err
+	}
+
+	for _, condition := range conditions {
+		conditionType, _, _ := unstructured.NestedString(condition, "type")
+		status, _, _ := unstructured.NestedString(condition, "status")
+		reason, _, _ := unstructured.NestedString(condition, "reason")
+
+		line := fmt.Sprintf("%s=%s", conditionType, status)
+		if reason != "" {
+			line += " " + reason
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// Follow a Deployment rollout, logging its progress on every change,
+// until the controller has seen the latest generation and all desired
+// replicas are updated, available and ready. A rollout that exceeded its
+// progress deadline fails.
+func waitForRollout(ctx context.Context, dynamicClient dynamic.Interface, namespace, name string) error {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	resource := kube.NamespacedResource(dynamicClient, gvr, namespace)
+	for {
+		deployment, err := kube.GetResource(ctx, resource, name)
+		if err != nil {
+			return err
+		}
+		if done, err := rolloutDone(deployment); done || err != nil {
+			return err
+		}
+
+		// Watch from the version just read until the rollout settles or the
+		// server ends the watch
+		watcher, err := resource.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: deployment.GetResourceVersion(),
+		})
+		if err != nil {
+			return err
+		}
+		for event := range watcher.ResultChan() {
+			if event.Type == watch.Deleted {
+				watcher.Stop()
+				return fmt.Errorf("deployment %q was deleted during the rollout", name)
+			}
+			obj, ok := event.Object.(*unstructured.Unstructured)
+			if !ok || event.Type == watch.Error {
+				break
+			}
+			if done, err := rolloutDone(obj); done || err != nil {
+				watcher.Stop()
+				return err
+			}
+		}
+		watcher.Stop()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Log the rollout progress of a Deployment and report whether it finished
+func rolloutDone(deployment *unstructured.Unstructured) (bool, error) {
+	obj := deployment.Object
+	replicas, found, _ := unstructured.NestedInt64(obj, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	observed, _, _ := unstructured.NestedInt64(obj, "status", "observedGeneration")
+	updated, _, _ := unstructured.NestedInt64(obj, "status", "updatedReplicas")
+	available, _, _ := unstructured.NestedInt64(obj, "status", "availableReplicas")
+	ready, _, _ := unstructured.NestedInt64(obj, "status", "readyReplicas")
+
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if condition["type"] == "Progressing" && condition["status"] == "False" && condition["reason"] == "ProgressDeadlineExceeded" {
+			message, _ := condition["message"].(string)
+			return false, fmt.Errorf("deployment %q exceeded its progress deadline: %s", deployment.GetName(), message)
+		}
+	}
+
+	// Progress goes to the log so it doesn't mix into -o json on stdout
+	slog.Info("Rollout progress", "name", deployment.GetName(), "updated", updated, "replicas", replicas, "available", available, "ready", ready)
+	return observed >= deployment.GetGeneration() && updated == replicas && available == replicas && ready == replicas, nil
+}
@@ -0,0 +1,931 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/itchyny/gojq"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+
+	"gitops/pkg/kube"
+)
+
+// Clients, parsed flags and results of applying the manifests to one cluster
+type runner struct {
+	config          *rest.Config
+	clientset       kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	discoveryClient discovery.CachedDiscoveryInterface
+	mapper          meta.RESTMapper
+
+	// Namespace of namespaced manifests that set neither -n nor metadata.namespace
+	defaultNamespace string
+
+	// Flags parsed once for all manifest documents
+	jqCode          *gojq.Code
+	jqVals          []interface{}
+	patch           []byte
+	pt              types.PatchType
+	labelEdits      []metadataEdit
+	annotationEdits []metadataEdit
+	deleteOpts      metav1.DeleteOptions
+	execArgs        []string
+
+	// Errors of all manifests, so one failure doesn't hide the others, and
+	// the results the later phases work on, guarded by mu
+	mu      sync.Mutex
+	errs    []error
+	failed  map[*unstructured.Unstructured]bool
+	watches []watchTarget
+
+	// Applied manifests and their resource clients, for the delete phase
+	applied   []*unstructured.Unstructured
+	resources map[*unstructured.Unstructured]dynamic.ResourceInterface
+
+	// Namespaces of applied objects, searched for pods with --logs
+	appliedNS map[string]bool
+
+	// Manifest names of each resource type and namespace, kept by --prune
+	pruneKeep map[pruneScope]map[string]bool
+
+	// Planned actions of the plan and apply commands, computed once before
+	// anything is applied
+	planned map[*unstructured.Unstructured]planAction
+
+	// Namespaces already ensured with --create-namespace. Workers of a phase
+	// wait for each other so a namespace is only created once.
+	nsMu      sync.Mutex
+	ensuredNS map[string]bool
+}
+
+// Apply, inspect and delete every manifest document on the current cluster
+func run(ctx context.Context, yamlDocs [][]byte) error {
+	if err := validateRunFlags(); err != nil {
+		return err
+	}
+	r, err := newRunner()
+	if err != nil {
+		return err
+	}
+
+	// Decode all manifests up front so they can be applied in dependency order
+	manifests, err := decodeManifests(yamlDocs)
+	if err != nil {
+		return err
+	}
+	sortManifestsByKind(manifests)
+
+	// Check every manifest against the cluster's schema before changing anything
+	if schemaCheck {
+		if err := r.validateSchemas(manifests); err != nil {
+			return err
+		}
+	}
+
+	// Skip kinds the cluster doesn't serve, such as custom resources whose CRD
+	// isn't installed, instead of failing each of them at apply time
+	known, unknown := kube.PartitionKnownKinds(r.mapper, manifests)
+	if len(unknown) > 0 {
+		kinds := kube.UnknownKinds(unknown)
+		slog.Warn("These kinds are not registered in the cluster, skipping them", "kinds", strings.Join(kinds, ", "), "objects", len(unknown))
+		if !skipUnknown {
+			r.errs = append(r.errs, fmt.Errorf("kinds not registered in the cluster: %s", strings.Join(kinds, ", ")))
+		}
+	}
+	manifests = known
+
+	// Compare every manifest with the cluster before changing anything
+	if planMode != "" {
+		if err := r.plan(ctx, manifests); err != nil {
+			return err
+		}
+		if planMode == planOnly || len(r.errs) > 0 {
+			return utilerrors.NewAggregate(r.errs)
+		}
+	}
+
+	r.applyPhases(ctx, manifests)
+
+	// Delete managed objects that were removed from the manifests
+	if prune && !watchMode && !diffMode {
+		r.prune(ctx)
+	}
+
+	// The apply command keeps what it applied, only the default flow goes on
+	// to delete the manifests again
+	if planMode != "" {
+		slog.Info("Finished", "succeeded", len(manifests)-len(r.failed), "failed", len(r.failed))
+		return utilerrors.NewAggregate(r.errs)
+	}
+
+	if !watchMode && !diffMode {
+		r.podActions(ctx)
+	}
+
+	// Watch on the signal context until interrupted, --timeout only bounds
+	// the calls made before
+	if watchMode {
+		if err := watchAll(ctx, r.dynamicClient, r.watches); err != nil {
+			r.errs = append(r.errs, err)
+		}
+		return utilerrors.NewAggregate(r.errs)
+	}
+
+	r.deleteApplied(ctx)
+
+	slog.Info("Finished", "succeeded", len(manifests)-len(r.failed), "failed", len(r.failed))
+	return utilerrors.NewAggregate(r.errs)
+}
+
+// Reject flag values that would only fail once manifests are being applied
+func validateRunFlags() error {
+	if err := validateOutput(output); err != nil {
+		return err
+	}
+	if dryRun != dryRunNone && dryRun != dryRunClient && dryRun != dryRunServer {
+		return fmt.Errorf("unknown dry-run mode %q, expected %s, %s or %s", dryRun, dryRunNone, dryRunClient, dryRunServer)
+	}
+	if fieldManager == "" {
+		return stderrors.New("--field-manager must not be empty")
+	}
+	if since < 0 {
+		return fmt.Errorf("--since must not be negative, got %s", since)
+	}
+	if parallelism < 1 {
+		return fmt.Errorf("--parallelism must be at least 1, got %d", parallelism)
+	}
+	if _, err := fields.ParseSelector(fieldSel); err != nil {
+		return fmt.Errorf("invalid field selector %q: %w", fieldSel, err)
+	}
+	return nil
+}
+
+// Parse the flags shared by all manifests and create the cluster clients
+func newRunner() (*runner, error) {
+	r := &runner{
+		failed:    make(map[*unstructured.Unstructured]bool),
+		resources: make(map[*unstructured.Unstructured]dynamic.ResourceInterface),
+		appliedNS: make(map[string]bool),
+		pruneKeep: make(map[pruneScope]map[string]bool),
+		planned:   make(map[*unstructured.Unstructured]planAction),
+		ensuredNS: make(map[string]bool),
+	}
+
+	var err error
+	r.deleteOpts, err = deleteOptions()
+	if err != nil {
+		return nil, err
+	}
+	if execCommand != "" {
+		r.execArgs, err = splitCommand(execCommand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exec: %w", err)
+		}
+	}
+	r.labelEdits, err = parseMetadataEdits(setLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --set-label: %w", err)
+	}
+	r.annotationEdits, err = parseMetadataEdits(setAnnots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --set-annotation: %w", err)
+	}
+
+	// Read the patch once for all manifest objects
+	r.pt, err = parsePatchType(patchType)
+	if err != nil {
+		return nil, err
+	}
+	if patchFile != "" {
+		r.patch, err = os.ReadFile(patchFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading patch: %w", err)
+		}
+	}
+
+	// Compile the jq query once for all manifest documents
+	jqNames, jqVals, err := kube.ParseJqArgs(jqArgs)
+	if err != nil {
+		return nil, err
+	}
+	r.jqVals = jqVals
+	if jqQuery != "" {
+		r.jqCode, err = kube.CompileJq(jqQuery, jqNames...)
+		if err != nil {
+			return nil, fmt.Errorf("compiling jq query: %w", err)
+		}
+	}
+
+	// Load Kubernetes configuration from the pod environment or the kubeconfig file
+	r.config, err = buildConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading cluster config: %w", err)
+	}
+
+	// Create a Kubernetes clientset and dynamic client
+	r.clientset, err = kubernetes.NewForConfig(r.config)
+	if err != nil {
+		return nil, err
+	}
+	r.dynamicClient, err = dynamic.NewForConfig(r.config)
+	if err != nil {
+		return nil, err
+	}
+	r.discoveryClient, err = newDiscoveryClient(r.config)
+	if err != nil {
+		return nil, err
+	}
+	r.mapper = restmapper.NewDeferredDiscoveryRESTMapper(r.discoveryClient)
+	r.defaultNamespace = contextNamespace()
+	return r, nil
+}
+
+// Decode the manifest documents into unstructured objects
+func decodeManifests(yamlDocs [][]byte) ([]*unstructured.Unstructured, error) {
+	// Create a new scheme and add the necessary types
+	scheme := runtime.NewScheme()
+	metav1.AddToGroupVersion(scheme, metav1.SchemeGroupVersion)
+
+	// Create a new codec object that can handle the necessary types
+	codecs := serializer.NewCodecFactory(scheme)
+
+	// Create a new YAML decoder using the scheme and codec object
+	decoder := codecs.UniversalDeserializer()
+
+	var manifests []*unstructured.Unstructured
+	for i, yamlDoc := range yamlDocs {
+		// The decoder's error for a missing apiVersion or kind is cryptic
+		if err := validateTypeMeta(yamlDoc); err != nil {
+			return nil, fmt.Errorf("manifest document %d: %w", i+1, err)
+		}
+
+		// Decode the manifest into a runtime.Object
+		manifestObj := &unstructured.Unstructured{}
+		if _, _, err := decoder.Decode(yamlDoc, nil, manifestObj); err != nil {
+			return nil, fmt.Errorf("decoding manifest document %d: %w", i+1, err)
+		}
+		normalizeAPIVersion(manifestObj)
+		manifests = append(manifests, manifestObj)
+	}
+	return manifests, nil
+}
+
+// Validate every manifest against the cluster's OpenAPI schema
+func (r *runner) validateSchemas(manifests []*unstructured.Unstructured) error {
+	validator, err := newSchemaValidator(r.discoveryClient)
+	if err != nil {
+		return err
+	}
+	var invalid []error
+	for _, manifestObj := range manifests {
+		if err := validator.validate(manifestObj); err != nil {
+			slog.Error("Manifest is invalid", "kind", manifestObj.GetKind(), "name", manifestObj.GetName(), "err", err)
+			invalid = append(invalid, fmt.Errorf("%s %q: %w", manifestObj.GetKind(), manifestObj.GetName(), err))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("validation failed, nothing was applied: %w", utilerrors.NewAggregate(invalid))
+	}
+	return nil
+}
+
+// Record the failure of a manifest, safe to call from concurrent workers
+func (r *runner) fail(obj *unstructured.Unstructured, msg string, err error) {
+	slog.Error(msg, "kind", obj.GetKind(), "name", obj.GetName(), "err", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Errorf("%s %q: %w", obj.GetKind(), obj.GetName(), err))
+	r.failed[obj] = true
+}
+
+// Whether a step of the manifest failed
+func (r *runner) hasFailed(obj *unstructured.Unstructured) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed[obj]
+}
+
+// Resolve the resource type and namespace a manifest is applied to, and set
+// the resolved version and namespace on the manifest so the object matches
+// the request
+func (r *runner) resolve(manifestObj *unstructured.Unstructured) (schema.GroupVersionResource, string, error) {
+	gvk := manifestObj.GroupVersionKind()
+
+	// Use the preferred version when the manifest leaves it out, and send
+	// the object with it
+	if gvk.Version == "" {
+		version, err := kube.PreferredVersion(r.discoveryClient, gvk.Group, gvk.Kind)
+		if err != nil {
+			return schema.GroupVersionResource{}, "", fmt.Errorf("resolving version: %w", err)
+		}
+		gvk.Version = version
+		manifestObj.SetAPIVersion(gvk.GroupVersion().String())
+		slog.Debug("Using preferred version", "kind", gvk.Kind, "version", gvk.GroupVersion().String())
+	}
+
+	// Resolve the resource name and scope for the kind
+	mapping, err := kube.MappingForGVK(r.mapper, gvk)
+	if err != nil {
+		return schema.GroupVersionResource{}, "", err
+	}
+
+	// Cluster-scoped kinds are never namespaced, -n included
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return mapping.Resource, "", nil
+	}
+	namespace := resolveNamespace(manifestObj.GetNamespace(), r.defaultNamespace)
+	manifestObj.SetNamespace(namespace)
+	return mapping.Resource, namespace, nil
+}
+
+// Print the planned action of every manifest, and of the objects --prune
+// would delete
+func (r *runner) plan(ctx context.Context, manifests []*unstructured.Unstructured) error {
+	var entries []planEntry
+	planKeep := make(map[pruneScope]map[string]bool)
+	for _, manifestObj := range manifests {
+		gvr, namespace, err := r.resolve(manifestObj)
+		// Kinds still unknown here are defined by a CRD of the batch, so the
+		// custom resource can only be new
+		if meta.IsNoMatchError(err) {
+			r.planned[manifestObj] = planCreate
+			entries = append(entries, planEntry{action: planCreate, kind: manifestObj.GetKind(), namespace: resolveNamespace(manifestObj.GetNamespace(), r.defaultNamespace), name: manifestObj.GetName()})
+			continue
+		}
+		if err != nil {
+			r.fail(manifestObj, "Resolving resource failed", err)
+			continue
+		}
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		action, err := planManifest(stepCtx, kube.NamespacedResource(r.dynamicClient, gvr, namespace), manifestObj)
+		cancel()
+		if err != nil {
+			r.fail(manifestObj, "Planning manifest failed", err)
+			continue
+		}
+		r.planned[manifestObj] = action
+		entries = append(entries, planEntry{action: action, kind: manifestObj.GetKind(), namespace: namespace, name: manifestObj.GetName()})
+
+		scope := pruneScope{gvr: gvr, namespace: namespace}
+		if planKeep[scope] == nil {
+			planKeep[scope] = make(map[string]bool)
+		}
+		planKeep[scope][manifestObj.GetName()] = true
+	}
+	if prune {
+		for scope, keep := range planKeep {
+			stepCtx, cancel := context.WithTimeout(ctx, timeout)
+			candidates, err := pruneCandidates(stepCtx, r.dynamicClient, scope, pruneSel, keep)
+			cancel()
+			if err != nil {
+				slog.Error("Planning prune failed", "resource", scope.gvr.Resource, "err", err)
+				r.errs = append(r.errs, err)
+				continue
+			}
+			for _, item := range candidates {
+				entries = append(entries, planEntry{action: planDelete, kind: item.GetKind(), namespace: item.GetNamespace(), name: item.GetName()})
+			}
+		}
+	}
+	return printPlan(entries)
+}
+
+// Apply the manifests phase by phase in install order, with up to
+// --parallelism documents of a phase applied at once
+func (r *runner) applyPhases(ctx context.Context, manifests []*unstructured.Unstructured) {
+	for _, phase := range installPhases(manifests) {
+		var group errgroup.Group
+		group.SetLimit(parallelism)
+		for _, manifestObj := range phase {
+			manifestObj := manifestObj
+			group.Go(func() error {
+				r.applyManifest(ctx, manifestObj)
+				return nil
+			})
+		}
+		group.Wait()
+		r.refreshKinds(ctx, phase)
+	}
+}
+
+// Once the CustomResourceDefinitions of a phase are established, forget the
+// discovered kinds, so the custom resources of later phases resolve
+func (r *runner) refreshKinds(ctx context.Context, phase []*unstructured.Unstructured) {
+	if dryRun != dryRunNone || watchMode || diffMode {
+		return
+	}
+	var established bool
+	for _, manifestObj := range phase {
+		resource, ok := r.resources[manifestObj]
+		if manifestObj.GetKind() != "CustomResourceDefinition" || !ok || r.hasFailed(manifestObj) {
+			continue
+		}
+		if err := waitForEstablished(ctx, resource, manifestObj.GetName(), timeout); err != nil {
+			r.fail(manifestObj, "Waiting for CustomResourceDefinition failed", err)
+			continue
+		}
+		established = true
+	}
+
+	// Resetting the mapper also invalidates the discovery cache behind it
+	if mapper, ok := r.mapper.(meta.ResettableRESTMapper); ok && established {
+		mapper.Reset()
+	}
+}
+
+// Apply a single manifest, safe to call from concurrent workers
+func (r *runner) applyManifest(ctx context.Context, manifestObj *unstructured.Unstructured) {
+	gvr, namespace, err := r.resolve(manifestObj)
+	if err != nil {
+		r.fail(manifestObj, "Resolving resource failed", err)
+		return
+	}
+	slog.Debug("Using namespace", "namespace", namespace, "kind", manifestObj.GetKind(), "name", manifestObj.GetName())
+
+	// Get the resource from the dynamic client
+	resource := kube.NamespacedResource(r.dynamicClient, gvr, namespace)
+
+	// Buffer what the manifest prints, so the output of concurrent workers
+	// doesn't interleave
+	var out bytes.Buffer
+	defer r.flush(&out)
+
+	if watchMode {
+		r.addWatch(gvr, namespace)
+		return
+	}
+	if diffMode {
+		r.diff(ctx, &out, resource, manifestObj)
+		return
+	}
+
+	// Make sure the target namespace exists before the first object in it
+	if createNS && namespace != "" && dryRun != dryRunClient {
+		if err := r.ensureNamespace(ctx, namespace); err != nil {
+			r.fail(manifestObj, "Creating namespace failed", err)
+			return
+		}
+	}
+
+	// Tag the object so --prune only ever touches objects applied here
+	setManagedBy(manifestObj)
+	r.keep(pruneScope{gvr: gvr, namespace: namespace}, manifestObj.GetName())
+
+	r.write(ctx, resource, manifestObj)
+	r.update(ctx, resource, gvr, namespace, manifestObj)
+	r.waitFor(ctx, resource, namespace, manifestObj)
+	r.writeStatus(ctx, gvr, namespace, manifestObj)
+	r.inspect(ctx, &out, resource, gvr, namespace, manifestObj)
+	r.query(ctx, &out, resource, gvr, namespace, manifestObj)
+
+	// Keep the client around to delete the manifest once everything is applied
+	r.mu.Lock()
+	r.applied = append(r.applied, manifestObj)
+	r.resources[manifestObj] = resource
+	if namespace != "" {
+		r.appliedNS[namespace] = true
+	}
+	r.mu.Unlock()
+}
+
+// Write the buffered output of a manifest to stdout in one piece
+func (r *runner) flush(out *bytes.Buffer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := out.WriteTo(os.Stdout); err != nil {
+		slog.Error("Writing output failed", "err", err)
+	}
+}
+
+// Watch the manifest's kind with --watch instead of applying it
+func (r *runner) addWatch(gvr schema.GroupVersionResource, namespace string) {
+	target := watchTarget{gvr: gvr, namespace: namespace}
+	if allNS {
+		target.namespace = metav1.NamespaceAll
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !containsWatchTarget(r.watches, target) {
+		r.watches = append(r.watches, target)
+	}
+}
+
+// Preview what an apply would change
+func (r *runner) diff(ctx context.Context, out io.Writer, resource dynamic.ResourceInterface, manifestObj *unstructured.Unstructured) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	live, err := kube.GetResource(ctx, resource, manifestObj.GetName())
+	if errors.IsNotFound(err) {
+		live, err = nil, nil
+	}
+	if err != nil {
+		r.fail(manifestObj, "Getting live object failed", err)
+		return
+	}
+	diff, err := diffManifest(live, manifestObj)
+	if err != nil {
+		r.fail(manifestObj, "Diffing manifest failed", err)
+		return
+	}
+	fmt.Fprint(out, diff)
+}
+
+// Create the namespace unless an earlier manifest already ensured it
+func (r *runner) ensureNamespace(ctx context.Context, namespace string) error {
+	r.nsMu.Lock()
+	defer r.nsMu.Unlock()
+	if r.ensuredNS[namespace] {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	created, err := ensureNamespace(ctx, r.dynamicClient, namespace)
+	r.ensuredNS[namespace] = err == nil
+	if created {
+		slog.Info("Namespace created", "namespace", namespace)
+	}
+	return err
+}
+
+// Keep the named object of the scope from being pruned
+func (r *runner) keep(scope pruneScope, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pruneKeep[scope] == nil {
+		r.pruneKeep[scope] = make(map[string]bool)
+	}
+	r.pruneKeep[scope][name] = true
+}
+
+// Create, update or patch the object of the manifest
+func (r *runner) write(ctx context.Context, resource dynamic.ResourceInterface, manifestObj *unstructured.Unstructured) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	kind, name := manifestObj.GetKind(), manifestObj.GetName()
+	if r.planned[manifestObj] == planUnchanged {
+		slog.Info("Manifest unchanged, skipping", "kind", kind, "name", name)
+	} else if dryRun == dryRunClient {
+		slog.Info("Manifest would be applied (dry run)", "kind", kind, "name", name)
+	} else if r.patch != nil {
+		if _, err := patchResource(ctx, resource, name, r.patch, r.pt); err != nil {
+			r.fail(manifestObj, "Patching manifest failed", err)
+		} else {
+			slog.Info("Manifest patched", "kind", kind, "name", name)
+		}
+	} else if serverSide {
+		if _, err := serverSideApply(ctx, resource, manifestObj, forceApply); err != nil {
+			r.fail(manifestObj, "Applying manifest failed", err)
+		} else {
+			slog.Info("Manifest applied", "kind", kind, "name", name)
+		}
+	} else if _, created, err := applyResource(ctx, resource, manifestObj); err != nil {
+		r.fail(manifestObj, "Applying manifest failed", err)
+	} else if created {
+		slog.Info("Manifest created", "kind", kind, "name", name)
+	} else {
+		slog.Info("Manifest updated", "kind", kind, "name", name)
+	}
+}
+
+// Verify, label and scale the applied object
+func (r *runner) update(ctx context.Context, resource dynamic.ResourceInterface, gvr schema.GroupVersionResource, namespace string, manifestObj *unstructured.Unstructured) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	kind, name := manifestObj.GetKind(), manifestObj.GetName()
+
+	// Check that admission didn't drop or reject the object
+	if verify && dryRun == dryRunNone && !r.hasFailed(manifestObj) {
+		if err := verifyApplied(ctx, resource, name); err != nil {
+			slog.Warn("Verifying applied object failed", "kind", kind, "name", name, "err", err)
+		}
+	}
+
+	// Label and annotate the applied object, which a dry run may not have created
+	if dryRun == dryRunNone && !r.hasFailed(manifestObj) {
+		if err := editLabels(ctx, resource, name, r.labelEdits); err != nil {
+			r.fail(manifestObj, "Labeling failed", err)
+		}
+		if err := editAnnotations(ctx, resource, name, r.annotationEdits); err != nil {
+			r.fail(manifestObj, "Annotating failed", err)
+		}
+	}
+
+	// Scale the workload when asked to
+	if replicas, ok := scale[name]; ok && dryRun == dryRunNone {
+		if err := scaleResource(ctx, r.dynamicClient, gvr, namespace, name, replicas); err != nil {
+			r.fail(manifestObj, "Scaling failed", err)
+		} else {
+			slog.Info("Scaled", "kind", kind, "name", name, "replicas", replicas)
+		}
+	}
+}
+
+// Wait for the applied Deployment to become ready or finish its rollout.
+// The waits have their own deadlines, --wait-timeout and the Deployment's
+// progress deadline, instead of --timeout.
+func (r *runner) waitFor(ctx context.Context, resource dynamic.ResourceInterface, namespace string, manifestObj *unstructured.Unstructured) {
+	name := manifestObj.GetName()
+	if manifestObj.GetKind() != "Deployment" || dryRun != dryRunNone {
+		return
+	}
+
+	// Wait for the Deployment to roll out
+	if waitReady {
+		if err := waitForDeploymentReady(ctx, resource, name, waitTimeout); err != nil {
+			r.fail(manifestObj, "Waiting for deployment failed", err)
+		} else {
+			slog.Info("Deployment is ready", "name", name)
+		}
+	}
+
+	// Follow the Deployment rollout until it completes or stalls
+	if watchRollout && !r.hasFailed(manifestObj) {
+		if err := waitForRollout(ctx, r.dynamicClient, namespace, name); err != nil {
+			r.fail(manifestObj, "Rollout failed", err)
+		} else {
+			slog.Info("Rollout complete", "name", name)
+		}
+	}
+}
+
+// Create and update ignore status, so write it through the subresource
+func (r *runner) writeStatus(ctx context.Context, gvr schema.GroupVersionResource, namespace string, manifestObj *unstructured.Unstructured) {
+	if !applyStatus || dryRun != dryRunNone || r.hasFailed(manifestObj) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if status, found, err := unstructured.NestedMap(manifestObj.Object, "status"); err != nil {
+		r.fail(manifestObj, "Reading manifest status failed", err)
+	} else if found {
+		if _, err := updateStatus(ctx, r.dynamicClient, gvr, namespace, manifestObj.GetName(), status); err != nil {
+			r.fail(manifestObj, "Updating status failed", err)
+		} else {
+			slog.Info("Status updated", "kind", manifestObj.GetKind(), "name", manifestObj.GetName())
+		}
+	}
+}
+
+// Print the rollout status, events, status and export of the applied object.
+// Under a dry run only the events are read, the live object may not exist.
+func (r *runner) inspect(ctx context.Context, out io.Writer, resource dynamic.ResourceInterface, gvr schema.GroupVersionResource, namespace string, manifestObj *unstructured.Unstructured) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	kind, name := manifestObj.GetKind(), manifestObj.GetName()
+
+	// Report the rollout health of the Deployment
+	if rolloutStat && kind == "Deployment" && dryRun == dryRunNone {
+		if deployment, err := kube.GetResource(ctx, resource, name); err != nil {
+			r.fail(manifestObj, "Getting rollout status failed", err)
+		} else if err := printConditions(out, deployment); err != nil {
+			r.fail(manifestObj, "Reading rollout status failed", err)
+		}
+	}
+
+	// Show why the object failed, such as FailedScheduling
+	if showEvents && r.hasFailed(manifestObj) {
+		eventNamespace := namespace
+		if eventNamespace == "" {
+			eventNamespace = metav1.NamespaceDefault
+		}
+		if events, err := getEventsFor(ctx, r.dynamicClient, eventNamespace, name); err != nil {
+			slog.Warn("Getting events failed", "kind", kind, "name", name, "err", err)
+		} else {
+			printEvents(out, events)
+		}
+	}
+
+	// Print the status, useful for custom resources with a rich status
+	if statusOnly && dryRun == dryRunNone {
+		if status, err := getStatus(ctx, r.dynamicClient, gvr, namespace, name); err != nil {
+			r.fail(manifestObj, "Getting status failed", err)
+		} else if err := printStatus(out, status); err != nil {
+			r.fail(manifestObj, "Printing status failed", err)
+		}
+	}
+
+	// Capture the live object as a reusable manifest
+	if export && dryRun == dryRunNone {
+		if live, err := kube.GetResource(ctx, resource, name); err != nil {
+			r.fail(manifestObj, "Exporting resource failed", err)
+		} else if err := printObject(out, exportResource(live), outputYAML); err != nil {
+			r.fail(manifestObj, "Exporting resource failed", err)
+		}
+	}
+}
+
+// List the container images and query the resources of the manifest's kind
+func (r *runner) query(ctx context.Context, out io.Writer, resource dynamic.ResourceInterface, gvr schema.GroupVersionResource, namespace string, manifestObj *unstructured.Unstructured) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// List and query in the manifest namespace, or in all of them with -A
+	listNamespace := namespace
+	listResource := resource
+	if allNS {
+		listNamespace = metav1.NamespaceAll
+		listResource = r.dynamicClient.Resource(gvr)
+	}
+
+	if _, ok := kube.PodSpecPaths[manifestObj.GetKind()]; ok && !quiet {
+		images, err := kube.GetContainerImages(listResource, ctx, allNS)
+		if err != nil {
+			r.fail(manifestObj, "Listing container images failed", err)
+		}
+		for _, image := range images {
+			fmt.Fprintln(out, image)
+		}
+	}
+
+	// List the resources of the same kind when a selector, -o, jq or
+	// --count-by asks for them
+	if r.jqCode == nil && countBy == "" && selector == "" && fieldSel == "" && output == "" {
+		return
+	}
+	items, err := kube.GetResourcesDynamically(r.dynamicClient, ctx, gvr, listNamespace, listParams(selector, fieldSel))
+	if err != nil {
+		r.fail(manifestObj, "Listing resources failed", err)
+	} else if r.jqCode != nil && jqValues {
+		results, err := kube.EvaluateJqItemsWithSource(items, r.jqCode, r.jqVals...)
+		if err != nil {
+			r.fail(manifestObj, "Evaluating jq query failed", err)
+		}
+		for _, result := range results {
+			fmt.Fprintln(out, formatJqResult(result))
+		}
+	} else {
+		if r.jqCode != nil {
+			items, err = kube.FilterByJq(items, r.jqCode, r.jqVals...)
+			if err != nil {
+				r.fail(manifestObj, "Evaluating jq query failed", err)
+			}
+		}
+		if countBy != "" {
+			fmt.Fprintln(out, formatSummary(summarize(items, countBy)))
+		} else if err := printObjects(out, items, output, allNS); err != nil {
+			r.fail(manifestObj, "Printing resources failed", err)
+		}
+	}
+}
+
+// Delete the managed objects of the applied kinds that are no longer in the
+// manifests
+func (r *runner) prune(ctx context.Context) {
+	for scope, keep := range r.pruneKeep {
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		pruned, err := pruneResources(stepCtx, r.dynamicClient, scope, pruneSel, keep, r.deleteOpts)
+		cancel()
+		for _, name := range pruned {
+			slog.Info("Resource pruned", "resource", scope.gvr.Resource, "namespace", scope.namespace, "name", name)
+		}
+		if err != nil {
+			slog.Error("Pruning failed", "resource", scope.gvr.Resource, "err", err)
+			r.errs = append(r.errs, err)
+		}
+	}
+}
+
+// Namespaces searched for the pods of --logs, --exec and --port-forward
+func (r *runner) podNamespaces() []string {
+	if allNS {
+		return []string{metav1.NamespaceAll}
+	}
+	namespaces := make([]string, 0, len(r.appliedNS))
+	for namespace := range r.appliedNS {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// The first running pod matched by -l, for --exec and --port-forward
+func (r *runner) findPod(ctx context.Context) (*corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, namespace := range r.podNamespaces() {
+		pod, err := findRunningPod(ctx, r.clientset, namespace, selector)
+		if err != nil || pod != nil {
+			return pod, err
+		}
+	}
+	return nil, fmt.Errorf("no running pod matches selector %q", selector)
+}
+
+// Print the logs of, run a command in and forward ports to the pods matched
+// by -l, before the manifests are deleted. The command and the forwarding
+// run until they end or are interrupted, not bounded by --timeout.
+func (r *runner) podActions(ctx context.Context) {
+	if showLogs {
+		for _, namespace := range r.podNamespaces() {
+			stepCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := printPodLogs(stepCtx, r.clientset, namespace, selector)
+			cancel()
+			if err != nil {
+				slog.Error("Getting pod logs failed", "namespace", namespace, "err", err)
+				r.errs = append(r.errs, err)
+			}
+		}
+	}
+
+	// Run the --exec command in the pod
+	if r.execArgs != nil {
+		if pod, err := r.findPod(ctx); err != nil {
+			slog.Error("Exec failed", "err", err)
+			r.errs = append(r.errs, err)
+		} else {
+			slog.Info("Running command", "namespace", pod.Namespace, "pod", pod.Name, "command", r.execArgs)
+			if execStatus, err = execInPod(ctx, r.config, r.clientset, pod, "", r.execArgs); err != nil {
+				slog.Error("Exec failed", "err", err)
+				r.errs = append(r.errs, err)
+			}
+		}
+	}
+
+	// Forward ports to the pod until interrupted
+	if len(forwardPorts) > 0 {
+		if pod, err := r.findPod(ctx); err != nil {
+			slog.Error("Port-forward failed", "err", err)
+			r.errs = append(r.errs, err)
+		} else {
+			slog.Info("Forwarding ports", "namespace", pod.Namespace, "pod", pod.Name, "ports", forwardPorts)
+			if err := portForward(ctx, r.config, pod.Namespace, pod.Name, forwardPorts); err != nil {
+				slog.Error("Port-forward failed", "err", err)
+				r.errs = append(r.errs, err)
+			}
+		}
+	}
+}
+
+// Delete the manifests in reverse install order, leaving objects owned by
+// another manifest to the garbage collector
+func (r *runner) deleteApplied(ctx context.Context) {
+	toDelete, owned := deletionOrder(r.applied)
+	for _, manifestObj := range owned {
+		slog.Info("Skipping delete, the owner's deletion will garbage collect it", "kind", manifestObj.GetKind(), "name", manifestObj.GetName())
+	}
+	for _, manifestObj := range toDelete {
+		resource := r.resources[manifestObj]
+		kind := manifestObj.GetKind()
+
+		if dryRun == dryRunClient {
+			slog.Info("Manifest would be deleted (dry run)", "kind", kind, "name", manifestObj.GetName())
+		} else if err := deleteObject(ctx, resource, manifestObj.GetName(), r.deleteOpts); err != nil {
+			r.fail(manifestObj, "Deleting manifest failed", err)
+		} else {
+			slog.Info("Manifest deleted", "kind", kind, "name", manifestObj.GetName())
+		}
+
+		// Check whether the manifest is still present
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		_, err := kube.GetResource(stepCtx, resource, manifestObj.GetName())
+		cancel()
+		if errors.IsNotFound(err) {
+			slog.Info("Resource not found", "kind", kind, "name", manifestObj.GetName())
+		} else if err != nil {
+			r.fail(manifestObj, "Getting resource failed", err)
+		} else {
+			slog.Info("Resource found", "kind", kind, "name", manifestObj.GetName())
+		}
+	}
+}
+
+// Delete an object within --timeout, or wait up to --wait-timeout until it
+// is gone with --wait-delete
+func deleteObject(ctx context.Context, resource dynamic.ResourceInterface, name string, options metav1.DeleteOptions) error {
+	if waitDelete {
+		return ensureDeleted(ctx, resource, name, waitTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return retryTransient(func() error {
+		return resource.Delete(ctx, name, options)
+	})
+}
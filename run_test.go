@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/restmapper"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Fake discovery serving v1 configmaps and namespaces, and apps/v1 deployments
+func newFakeDiscovery() *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+				{Name: "namespaces", Kind: "Namespace"},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment", Namespaced: true}},
+		},
+	}}}
+}
+
+// A runner resolving manifests through the fake discovery, cached like the
+// real one
+func newTestRunner(fake *fakediscovery.FakeDiscovery) *runner {
+	discoveryClient := memory.NewMemCacheClient(fake)
+	return &runner{
+		discoveryClient:  discoveryClient,
+		mapper:           restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient),
+		defaultNamespace: "default",
+	}
+}
+
+func TestResolveSetsNamespaceArg(t *testing.T) {
+	namespaceArg = "staging"
+	defer func() { namespaceArg = "" }()
+
+	r := newTestRunner(newFakeDiscovery())
+	manifestObj := newConfigMap("app", map[string]interface{}{"key": "value"})
+	gvr, namespace, err := r.resolve(manifestObj)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if namespace != "staging" {
+		t.Errorf("namespace = %q, want staging", namespace)
+	}
+
+	// The fake client rejects objects whose namespace differs from the request's
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	resource := client.Resource(gvr).Namespace(namespace)
+	if _, _, err := applyResource(context.Background(), resource, manifestObj); err != nil {
+		t.Fatalf("applyResource: %v", err)
+	}
+	stored, err := resource.Get(context.Background(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the applied object: %v", err)
+	}
+	if stored.GetNamespace() != "staging" {
+		t.Errorf("stored namespace = %q, want staging", stored.GetNamespace())
+	}
+}
+
+func TestRefreshKindsResolvesCustomResourcesOfTheBatch(t *testing.T) {
+	fake := newFakeDiscovery()
+	r := newTestRunner(fake)
+	widget := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "app"},
+	}}
+	if _, _, err := r.resolve(widget); !meta.IsNoMatchError(err) {
+		t.Fatalf("resolve before the CRD = %v, want a no-match error", err)
+	}
+
+	// Apply the CRD: the fake serves it as established and discovery picks
+	// up its kind
+	crdGVR := schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "widgets.example.com"},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Established", "status": "True"},
+		}},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), crd)
+	r.resources = map[*unstructured.Unstructured]dynamic.ResourceInterface{crd: client.Resource(crdGVR)}
+	fake.Resources = append(fake.Resources, &metav1.APIResourceList{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget", Namespaced: true}},
+	})
+
+	r.refreshKinds(context.Background(), []*unstructured.Unstructured{crd})
+	gvr, namespace, err := r.resolve(widget)
+	if err != nil {
+		t.Fatalf("resolve after the CRD: %v", err)
+	}
+	if gvr.Resource != "widgets" || namespace != "default" {
+		t.Errorf("resolved to %v in %q, want widgets in default", gvr, namespace)
+	}
+}
+
+func TestResolveOmittedVersion(t *testing.T) {
+	for _, apiVersion := range []string{"apps/", "apps"} {
+		doc := []byte(`{"apiVersion": "` + apiVersion + `", "kind": "Deployment", "metadata": {"name": "ginx"}}`)
+		manifests, err := decodeManifests([][]byte{doc})
+		if err != nil {
+			t.Fatalf("decodeManifests(%q): %v", apiVersion, err)
+		}
+
+		r := newTestRunner(newFakeDiscovery())
+		gvr, namespace, err := r.resolve(manifests[0])
+		if err != nil {
+			t.Fatalf("resolve(%q): %v", apiVersion, err)
+		}
+		want := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		if gvr != want || namespace != "default" {
+			t.Errorf("resolve(%q) = %v in %q, want %v in default", apiVersion, gvr, namespace, want)
+		}
+		if got := manifests[0].GetAPIVersion(); got != "apps/v1" {
+			t.Errorf("apiVersion %q resolved to %q, want apps/v1", apiVersion, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"gitops/pkg/kube"
+)
+
+// Get the status subtree of an object, empty when the object has no status
+func getStatus(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string) (map[string]interface{}, error) {
+	obj, err := kube.GetResource(ctx, kube.NamespacedResource(dynamicClient, gvr, namespace), name)
+	if err != nil {
+		return nil, err
+	}
+
+	status, found, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil {
+		return nil, fmt.Errorf("reading status of %q: %w", name, err)
+	}
+	if !found {
+		return map[string]interface{}{}, nil
+	}
+	return status, nil
+}
+
+// Replace the status of an object through the status subresource. Kinds
+// without one, such as ConfigMaps, reject the request.
+func updateStatus(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, status map[string]interface{}) (*unstructured.Unstructured, error) {
+	resource := kube.NamespacedResource(dynamicClient, gvr, namespace)
+	var updated *unstructured.Unstructured
+	err := retryOnConflict(func() error {
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedMap(obj.Object, status, "status"); err != nil {
+			return err
+		}
+		updated, err = resource.UpdateStatus(ctx, obj, metav1.UpdateOptions{DryRun: dryRunOptions(), FieldManager: fieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating status of %q: %w", name, err)
+	}
+	return updated, nil
+}
+
+// Print a status block to w as a YAML document
+func printStatus(w io.Writer, status map[string]interface{}) error {
+	data, err := yaml.Marshal(status)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "---\n%s", data)
+	return nil
+}
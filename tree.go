@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+
+	"gitops/pkg/kube"
+)
+
+// A kind of object that controllers create on behalf of an owner
+type childKind struct {
+	kind string
+	gvr  schema.GroupVersionResource
+}
+
+var (
+	replicaSetKind = childKind{"ReplicaSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}}
+	podKind        = childKind{"Pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}}
+	jobKind        = childKind{"Job", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}}
+)
+
+// Kinds each workload kind spawns, searched for owner references to it
+var childKinds = map[string][]childKind{
+	"Deployment":  {replicaSetKind},
+	"ReplicaSet":  {podKind},
+	"StatefulSet": {podKind},
+	"DaemonSet":   {podKind},
+	"Job":         {podKind},
+	"CronJob":     {jobKind},
+}
+
+// Print the object and, indented below it, every object it owns, such as
+// Deployment -> ReplicaSet -> Pods
+func printTree(ctx context.Context, dynamicClient dynamic.Interface, obj *unstructured.Unstructured, depth int) error {
+	fmt.Printf("%s%s/%s\n", strings.Repeat("  ", depth), obj.GetKind(), obj.GetName())
+
+	for _, child := range childKinds[obj.GetKind()] {
+		items, err := kube.GetResourcesDynamically(dynamicClient, ctx, child.gvr, obj.GetNamespace(), listParams("", ""))
+		if err != nil {
+			return fmt.Errorf("listing children of %s %q: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+
+		for i := range items {
+			if !ownedBy(&items[i], obj) {
+				continue
+			}
+			// Lists leave the kind of their items unset for some servers
+			items[i].SetKind(child.kind)
+			if err := printTree(ctx, dynamicClient, &items[i], depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Whether an owner reference of obj points at the owner by UID
+func ownedBy(obj, owner *unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// Print the ownership tree of the --tree object, given as resource/name
+// such as deployment/ginx
+func runTree(ctx context.Context) error {
+	resource, name, ok := strings.Cut(treeRoot, "/")
+	if !ok || resource == "" || name == "" {
+		return fmt.Errorf("--tree %q must be in resource/name form, such as deployment/ginx", treeRoot)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	config, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("loading cluster config: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := newDiscoveryClient(config)
+	if err != nil {
+		return err
+	}
+	mapping, err := kube.MappingForResource(restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient), resource)
+	if err != nil {
+		return err
+	}
+
+	namespace := ""
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace = resolveNamespace("", contextNamespace())
+	}
+	root, err := kube.GetResource(ctx, kube.NamespacedResource(dynamicClient, mapping.Resource, namespace), name)
+	if err != nil {
+		return err
+	}
+	return printTree(ctx, dynamicClient, root, 0)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/kube-openapi/pkg/util/proto"
+	"k8s.io/kube-openapi/pkg/util/proto/validation"
+)
+
+// OpenAPI extension listing the kinds a schema definition describes
+const gvkExtension = "x-kubernetes-group-version-kind"
+
+// Validates objects against the OpenAPI schema served by the cluster
+type schemaValidator struct {
+	models proto.Models
+	byGVK  map[schema.GroupVersionKind]string
+}
+
+// Fetch the cluster's OpenAPI schema and index its definitions by kind
+func newSchemaValidator(discoveryClient discovery.OpenAPISchemaInterface) (*schemaValidator, error) {
+	doc, err := discoveryClient.OpenAPISchema()
+	if err != nil {
+		return nil, fmt.Errorf("fetching OpenAPI schema: %w", err)
+	}
+	models, err := proto.NewOpenAPIData(doc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI schema: %w", err)
+	}
+
+	v := &schemaValidator{models: models, byGVK: make(map[schema.GroupVersionKind]string)}
+	for _, name := range models.ListModels() {
+		for _, gvk := range modelKinds(models.LookupModel(name)) {
+			v.byGVK[gvk] = name
+		}
+	}
+	return v, nil
+}
+
+// Read the kinds of a definition from its x-kubernetes-group-version-kind
+// extension, which is decoded from YAML with interface keys
+func modelKinds(model proto.Schema) []schema.GroupVersionKind {
+	list, ok := model.GetExtensions()[gvkExtension].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var gvks []schema.GroupVersionKind
+	for _, entry := range list {
+		fields, ok := entry.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		group, _ := fields["group"].(string)
+		version, _ := fields["version"].(string)
+		kind, _ := fields["kind"].(string)
+		gvks = append(gvks, schema.GroupVersionKind{Group: group, Version: version, Kind: kind})
+	}
+	return gvks
+}
+
+// Validate an object against the schema of its kind, catching typos such
+// as "replcas". Kinds without a published schema pass unchecked.
+func (v *schemaValidator) validate(obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	name, ok := v.byGVK[gvk]
+	if !ok {
+		return nil
+	}
+	return utilerrors.NewAggregate(validation.ValidateModel(obj.Object, v.models.LookupModel(name), gvk.Kind))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// How often to re-check an object while waiting on it
+const pollInterval = 2 * time.Second
+
+// Block until the Deployment has as many ready replicas as it wants, the
+// timeout elapses or the context is done
+func waitForDeploymentReady(ctx context.Context, resource dynamic.ResourceInterface, name string, timeout time.Duration) error {
+	err := wait.PollImmediateWithContext(ctx, pollInterval, timeout, func(ctx context.Context) (bool, error) {
+		deployment, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		replicas, _, err := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+		if err != nil {
+			return false, err
+		}
+		readyReplicas, _, err := unstructured.NestedInt64(deployment.Object, "status", "readyReplicas")
+		if err != nil {
+			return false, err
+		}
+		return readyReplicas == replicas, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %v waiting for deployment %q to become ready", timeout, name)
+	}
+	return err
+}
+
+// Delete the object and block until it is gone, the timeout elapses or the
+// context is done. An object that is already gone counts as deleted. While
+// finalizers keep the object around they are logged, and a server-side dry
+// run returns right after the Delete since nothing is removed.
+func ensureDeleted(ctx context.Context, resource dynamic.ResourceInterface, name string, timeout time.Duration) error {
+	options, err := deleteOptions()
+	if err != nil {
+		return err
+	}
+	err = retryTransient(func() error {
+		return resource.Delete(ctx, name, options)
+	})
+	if errors.IsNotFound(err) || (err == nil && dryRun == dryRunServer) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var finalizers []string
+	err = wait.PollImmediateWithContext(ctx, pollInterval, timeout, func(ctx context.Context) (bool, error) {
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// Only log when the blocking finalizers change
+		if current := obj.GetFinalizers(); strings.Join(current, ",") != strings.Join(finalizers, ",") {
+			finalizers = current
+			if len(finalizers) > 0 {
+				slog.Info("Waiting for finalizers", "kind", obj.GetKind(), "name", name, "finalizers", finalizers)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %v waiting for %q to be deleted, finalizers: %v", timeout, name, finalizers)
+	}
+	return err
+}
+
+// Block until the CustomResourceDefinition is Established, so the API server
+// serves its kind, the timeout elapses or the context is done
+func waitForEstablished(ctx context.Context, resource dynamic.ResourceInterface, name string, timeout time.Duration) error {
+	err := wait.PollImmediateWithContext(ctx, pollInterval, timeout, func(ctx context.Context) (bool, error) {
+		crd, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		conditions, err := kube.NestedMapSlice(crd.Object, "status", "conditions")
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range conditions {
+			if condition["type"] == "Established" && condition["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %v waiting for CustomResourceDefinition %q to be established", timeout, name)
+	}
+	return err
+}
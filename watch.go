@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+
+	"gitops/pkg/kube"
+)
+
+// Print every change to the resources of a given type until the context is
+// cancelled, re-establishing the watch whenever the server ends it. A
+// context that hit its deadline is reported instead of exiting cleanly. Watches
+// resume from the last seen resourceVersion so no events are missed or
+// replayed, and start over from a fresh List once that version expired.
+func watchResources(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace string) error {
+	resource := kube.NamespacedResource(dynamicClient, gvr, namespace)
+	var resourceVersion string
+	for {
+		latest, err := watchOnce(ctx, resource, gvr, resourceVersion)
+		if errors.IsGone(err) || errors.IsResourceExpired(err) {
+			slog.Debug("Watch resource version expired, listing again", "resource", gvr.Resource, "resourceVersion", resourceVersion)
+			latest, err = currentResourceVersion(ctx, resource)
+		}
+		if err != nil {
+			return err
+		}
+		resourceVersion = latest
+		if err := ctx.Err(); err != nil {
+			if stderrors.Is(err, context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("watching %s: %w", gvr.Resource, err)
+		}
+		slog.Debug("Watch closed, re-establishing", "resource", gvr.Resource, "resourceVersion", resourceVersion)
+	}
+}
+
+// Consume a single watch from resourceVersion until its channel closes or it
+// reports an error, returning the latest resourceVersion seen. Expired
+// resource versions are returned as errors so the caller can resync.
+func watchOnce(ctx context.Context, resource dynamic.ResourceInterface, gvr schema.GroupVersionResource, resourceVersion string) (string, error) {
+	watcher, err := resource.Watch(ctx, metav1.ListOptions{
+		ResourceVersion:     resourceVersion,
+		AllowWatchBookmarks: true,
+	})
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer watcher.Stop()
+
+	for event := range watcher.ResultChan() {
+		if event.Type == watch.Error {
+			err := errors.FromObject(event.Object)
+			if errors.IsGone(err) || errors.IsResourceExpired(err) {
+				return resourceVersion, err
+			}
+			slog.Warn("Watch failed", "resource", gvr.Resource, "err", err)
+			return resourceVersion, nil
+		}
+
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		resourceVersion = obj.GetResourceVersion()
+
+		// Bookmarks only carry the resourceVersion to resume from
+		if event.Type == watch.Bookmark {
+			continue
+		}
+		// JSON Lines output streams the changed objects themselves
+		if output == outputJSONL {
+			if err := printObject(os.Stdout, obj, outputJSONL); err != nil {
+				return resourceVersion, err
+			}
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", event.Type, gvr.Resource, obj.GetName())
+	}
+	return resourceVersion, nil
+}
+
+// List the resources to get the current resourceVersion of the collection
+func currentResourceVersion(ctx context.Context, resource dynamic.ResourceInterface) (string, error) {
+	list, err := kube.ListWithRetry(ctx, resource, metav1.ListOptions{}, listRetries)
+	if err != nil {
+		return "", err
+	}
+	return list.GetResourceVersion(), nil
+}
+
+// A resource type and namespace to watch
+type watchTarget struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+// Watch all targets concurrently until the context is cancelled
+func watchAll(ctx context.Context, dynamicClient dynamic.Interface, targets []watchTarget) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target watchTarget) {
+			defer wg.Done()
+			errs[i] = watchResources(ctx, dynamicClient, target.gvr, target.namespace)
+		}(i, target)
+	}
+	wg.Wait()
+	return utilerrors.NewAggregate(errs)
+}
+
+// Check whether the target is already being watched
+func containsWatchTarget(targets []watchTarget, target watchTarget) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}